@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
+	"github.com/k1nho/letsgo/internal/data"
+	"github.com/k1nho/letsgo/internal/jsonlog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// accessLogEntry: request data only known to the inner handlers, filled in while the request goes down the chain
+type accessLogEntry struct {
+	user *data.User
+}
+
+// accessLog: writes one log line per request, requests to excluded paths are skipped and the rest are sampled,
+// except for server errors which are always logged
+func (app *application) accessLog(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.accessLog.enabled || app.accessLogExcluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &accessLogEntry{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry))
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		if metrics.Code < http.StatusInternalServerError && rand.Float64() >= app.config.accessLog.sampleRate {
+			return
+		}
+
+		properties := jsonlog.Properties{
+			"request_id":     app.contextGetRequestID(r),
+			"request_method": r.Method,
+			"request_path":   r.URL.Path,
+			"route":          app.routePattern(router, r),
+			"status":         metrics.Code,
+			"bytes":          metrics.Written,
+			"duration":       metrics.Duration,
+			"remote_ip":      app.contextGetClientIP(r),
+		}
+
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			properties["trace_id"] = sc.TraceID().String()
+		}
+
+		if entry.user != nil && !entry.user.IsAnonymous() {
+			properties["user_id"] = entry.user.ID
+		}
+
+		app.logger.Info("request completed", properties)
+	})
+}
+
+// accessLogExcluded: a path is excluded when it equals one of the configured paths or is nested under it
+func (app *application) accessLogExcluded(path string) bool {
+	for _, excluded := range app.config.accessLog.exclude {
+		if path == excluded || strings.HasPrefix(path, strings.TrimSuffix(excluded, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// routePattern: rebuilds the httprouter pattern that matches the request (e.g /v1/movies/:id), so requests can be grouped by route
+func (app *application) routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return ""
+	}
+
+	path := r.URL.Path
+
+	// a catch-all param holds the rest of the path including its leading slash, and it is always the last one
+	if n := len(params); n > 0 && strings.HasPrefix(params[n-1].Value, "/") {
+		path = strings.TrimSuffix(path, params[n-1].Value) + "/*" + params[n-1].Key
+		params = params[:n-1]
+	}
+
+	segments := strings.Split(path, "/")
+
+	j := 0
+	for i := range segments {
+		if j < len(params) && segments[i] == params[j].Value {
+			segments[i] = ":" + params[j].Key
+			j++
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/k1nho/letsgo/internal/jsonlog"
+	"github.com/k1nho/letsgo/internal/validator"
+)
+
+// showLogLevelHandler: Returns the current minimum log level (JSON)
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"level": app.logger.Level()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler: Changes the minimum log level without restarting the server (JSON)
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	level, err := jsonlog.ParseLevel(input.Level)
+	v.Check(err == nil, "level", "must be one of debug, info, warn, error, fatal or off")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	app.logger.Warn("log level changed", jsonlog.Properties{
+		"request_id": app.contextGetRequestID(r),
+		"user_id":    app.contextGetUser(r).ID,
+		"from":       previous,
+		"to":         level,
+	})
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"level": level}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/k1nho/letsgo/internal/jsonpatch"
+	"github.com/k1nho/letsgo/internal/validator"
+)
+
+const maxBatchOperations = 100
+
+type batchOperation struct {
+	Op      string `json:"op"`
+	ID      int64  `json:"id,omitempty"`
+	Version *int32 `json:"version,omitempty"`
+	// ContentType: the format of the movie of an update, as the Content-Type of PATCH /v1/movies/:id (application/json by default)
+	ContentType string          `json:"content_type,omitempty"`
+	Movie       json.RawMessage `json:"movie,omitempty"`
+}
+
+func (op batchOperation) mediaType() string {
+	if op.ContentType == "" {
+		return "application/json"
+	}
+	return op.ContentType
+}
+
+type batchResult struct {
+	Op     string          `json:"op"`
+	ID     int64           `json:"id,omitempty"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// responseRecorder: in-memory http.ResponseWriter, it lets every batch operation reuse the regular response and error helpers
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+func (rec *responseRecorder) succeeded() bool {
+	return rec.status < 400
+}
+
+// batchMoviesHandler: runs a list of create, update and delete operations on movies inside a single transaction (JSON)
+// With atomic set, the first failing operation rolls back the whole batch, otherwise every operation is committed or rolled back on its own.
+// Operations run through the same code as the single movie endpoints, updates accept the same formats given in content_type
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic     bool             `json:"atomic"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Operations) >= 1, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+
+		v.Check(validator.In(op.Op, "create", "update", "delete"), key, "op must be one of create, update or delete")
+		if op.Op == "update" || op.Op == "delete" {
+			v.Check(op.ID > 0, key, "id must be provided")
+		}
+		if op.Op == "create" || op.Op == "update" {
+			v.Check(len(op.Movie) > 0, key, "movie must be provided")
+		}
+		if op.Op == "update" {
+			v.Check(validator.In(op.mediaType(), "application/json", jsonpatch.MergePatchType, jsonpatch.JSONPatchType), key,
+				"content_type must be one of application/json, "+jsonpatch.MergePatchType+" or "+jsonpatch.JSONPatchType)
+		} else {
+			v.Check(op.ContentType == "", key, "content_type is only allowed on update")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tx, err := app.models.BeginTx(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	movies := app.models.Movies.WithTx(tx)
+	images := app.models.MovieImages.WithTx(tx)
+
+	// loaded once through the transaction, which already holds a connection of the pool
+	rules, err := app.movieRules(r.Context(), app.models.Genres.WithTx(tx))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the results are embedded as JSON in the envelope, only the envelope itself is written in the negotiated format
+	inner := app.contextSetFormat(r, lookupFormat("json"))
+
+	results := make([]batchResult, 0, len(input.Operations))
+	committed := true
+
+	// the blobs of the deleted movies are only deleted once the transaction is committed
+	var blobKeys []string
+
+	for i, op := range input.Operations {
+		// the savepoint lets a failed operation be undone without aborting the rest of the transaction
+		_, err = tx.ExecContext(r.Context(), "SAVEPOINT batch_operation")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		rec := newResponseRecorder()
+
+		switch op.Op {
+		case "create":
+			app.createMovie(rec, inner, movies, rules, bytes.NewReader(op.Movie))
+		case "update":
+			app.updateMovie(rec, inner, movies, rules, movieUpdate{
+				id:        op.ID,
+				version:   op.Version,
+				mediaType: op.mediaType(),
+				body:      bytes.NewReader(op.Movie),
+			})
+		case "delete":
+			keys := app.deleteMovie(rec, inner, movies, images, op.ID)
+			if rec.succeeded() {
+				blobKeys = append(blobKeys, keys...)
+			}
+		}
+
+		results = append(results, batchResult{Op: op.Op, ID: op.ID, Status: rec.status, Body: rec.body.Bytes()})
+
+		if rec.succeeded() {
+			_, err = tx.ExecContext(r.Context(), "RELEASE SAVEPOINT batch_operation")
+		} else {
+			_, err = tx.ExecContext(r.Context(), "ROLLBACK TO SAVEPOINT batch_operation")
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if input.Atomic && !rec.succeeded() {
+			committed = false
+
+			// the operations that ran before the failure are rolled back, and the ones after it are never run
+			for j := range results[:i] {
+				results[j].Status = http.StatusFailedDependency
+				results[j].Body = nil
+			}
+			for _, skipped := range input.Operations[i+1:] {
+				results = append(results, batchResult{Op: skipped.Op, ID: skipped.ID, Status: http.StatusFailedDependency})
+			}
+			break
+		}
+	}
+
+	if committed {
+		err = tx.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.deleteBlobs(blobKeys)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"committed": committed, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
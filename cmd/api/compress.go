@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/felixge/httpsnoop"
+)
+
+// encoder: the methods shared by the gzip and brotli writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// the levels favor speed, responses are compressed on every request rather than once ahead of time
+var encoderPools = map[string]*sync.Pool{
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	"gzip": {New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+}
+
+// compressedTypes: media types that are already compressed, compressing them again only costs CPU.
+// Entries ending with / match the whole type (image/ matches image/png)
+var compressedTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-brotli",
+	// pprof profiles are gzipped protocol buffers
+	"application/octet-stream",
+}
+
+// compress: compresses the response with the best encoding accepted by the client (br, then gzip). The body is buffered
+// until it reaches -compression-min-size, smaller bodies aren't worth the encoding overhead and are sent as they are
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.compression.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// caches must keep the compressed and uncompressed responses apart, even when this one isn't compressed
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{w: w, encoding: encoding, minSize: app.config.compression.minSize}
+		defer cw.Close()
+
+		// the hooks keep every optional interface of w (Flusher, Hijacker...) so the wrappers around this one, like the
+		// httpsnoop metrics, see the same writer they would without compression
+		next.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return cw.WriteHeader
+			},
+			Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return cw.Write
+			},
+			Flush: func(httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return cw.Flush
+			},
+			// io.Copy would hand the body straight to the connection, skipping the encoder
+			ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					return io.Copy(struct{ io.Writer }{cw}, src)
+				}
+			},
+			Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+				return cw.hijack(next)
+			},
+		}), r)
+	})
+}
+
+// negotiateEncoding: the accepted encoding with the highest quality value, br is preferred when both are equal.
+// Encodings not listed are accepted with the quality of *, an empty string means the body is sent as it is
+func negotiateEncoding(accept []string) string {
+	quality := map[string]float64{"br": -1, "gzip": -1}
+	wildcard := -1.0
+
+	for _, value := range accept {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+
+			q := 1.0
+			if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+
+			switch name {
+			case "*":
+				wildcard = q
+			case "x-gzip":
+				quality["gzip"] = q
+			default:
+				if _, ok := quality[name]; ok {
+					quality[name] = q
+				}
+			}
+		}
+	}
+
+	for name, q := range quality {
+		if q < 0 {
+			quality[name] = wildcard
+		}
+	}
+
+	switch {
+	case quality["br"] > 0 && quality["br"] >= quality["gzip"]:
+		return "br"
+	case quality["gzip"] > 0:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// compressWriter: holds the status and the start of the body back until it knows whether the response is worth compressing
+type compressWriter struct {
+	w        http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	enc      encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// informational responses (103 Early Hints) don't carry the body, they go out right away
+	if code >= 100 && code < 200 {
+		cw.w.WriteHeader(code)
+		return
+	}
+
+	if cw.decided || cw.status != 0 {
+		return
+	}
+
+	cw.status = code
+
+	// responses without a body and partial content (a range of the uncompressed representation) are never compressed
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.w.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+
+	if len(cw.buf) >= cw.minSize {
+		err := cw.decide(cw.compressible())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush: a flushed response (e.g a stream) can't wait for the minimum size, it is compressed from here on if its type allows it
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(cw.compressible())
+	}
+
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close: sends what is still buffered and finishes the compressed stream
+func (cw *compressWriter) Close() error {
+	if cw.hijacked {
+		return nil
+	}
+
+	if !cw.decided {
+		// nothing was written, net/http sends its implicit 200
+		if cw.status == 0 {
+			return nil
+		}
+
+		// the whole body is buffered and smaller than the minimum size
+		err := cw.decide(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+
+	cw.enc.Reset(nil)
+	encoderPools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+
+	return err
+}
+
+func (cw *compressWriter) hijack(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+	return func() (c net.Conn, rw *bufio.ReadWriter, err error) {
+		cw.hijacked = true
+		return next()
+	}
+}
+
+// compressible: the response wasn't encoded by the handler itself and its type isn't already compressed
+func (cw *compressWriter) compressible() bool {
+	header := cw.w.Header()
+
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	// net/http would sniff the type of the first write anyway, doing it here lets the type decide on compression
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+
+	for _, compressed := range compressedTypes {
+		if mediaType == compressed || strings.HasSuffix(compressed, "/") && strings.HasPrefix(mediaType, compressed) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decide: writes the status and the buffered body, through the encoder when compressing
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+
+	if compress {
+		header := cw.w.Header()
+		header.Set("Content-Encoding", cw.encoding)
+		// the length set by the handler is the one of the uncompressed body
+		header.Del("Content-Length")
+
+		cw.enc = encoderPools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.w)
+	}
+
+	cw.w.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+
+	_, err := cw.w.Write(buf)
+	return err
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func newCompressApp(minSize int) *application {
+	app := &application{}
+	app.config.compression.enabled = true
+	app.config.compression.minSize = minSize
+	return app
+}
+
+// decodeBody: the body of the response decoded with its Content-Encoding
+func decodeBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+
+	var r io.Reader = res.Body
+	switch res.Header.Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatalf("invalid gzip body: %v", err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(res.Body)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("invalid %s body: %v", res.Header.Get("Content-Encoding"), err)
+	}
+	return string(body)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"title":"Moana","year":2016}`, 100)
+	small := `{"status":"available"}`
+
+	// write: a handler writing body with the given content type and status, setting Content-Length like http.ServeContent
+	write := func(contentType string, status int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		wantStatus     int
+		wantEncoding   string
+		wantBody       string
+		wantType       string
+	}{
+		{
+			name:           "gzip",
+			acceptEncoding: "gzip",
+			handler:        write("application/json", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantEncoding:   "gzip",
+			wantBody:       large,
+		},
+		{
+			name:           "br preferred",
+			acceptEncoding: "gzip, deflate, br",
+			handler:        write("application/json", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantEncoding:   "br",
+			wantBody:       large,
+		},
+		{
+			name:           "gzip preferred by quality",
+			acceptEncoding: "br;q=0.5, gzip",
+			handler:        write("application/json", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantEncoding:   "gzip",
+			wantBody:       large,
+		},
+		{
+			name:           "no accepted encoding",
+			acceptEncoding: "",
+			handler:        write("application/json", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantBody:       large,
+		},
+		{
+			name:           "encodings refused",
+			acceptEncoding: "gzip;q=0, br;q=0",
+			handler:        write("application/json", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantBody:       large,
+		},
+		{
+			name:           "below the minimum size",
+			acceptEncoding: "gzip",
+			handler:        write("application/json", http.StatusOK, small),
+			wantStatus:     http.StatusOK,
+			wantBody:       small,
+		},
+		{
+			name:           "error status compressed",
+			acceptEncoding: "gzip",
+			handler:        write("application/json", http.StatusUnprocessableEntity, large),
+			wantStatus:     http.StatusUnprocessableEntity,
+			wantEncoding:   "gzip",
+			wantBody:       large,
+		},
+		{
+			name:           "image already compressed",
+			acceptEncoding: "gzip",
+			handler:        write("image/png", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantBody:       large,
+		},
+		{
+			name:           "octet stream already compressed",
+			acceptEncoding: "br",
+			handler:        write("application/octet-stream", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantBody:       large,
+		},
+		{
+			name:           "svg image compressed",
+			acceptEncoding: "gzip",
+			handler:        write("image/svg+xml", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantEncoding:   "gzip",
+			wantBody:       large,
+		},
+		{
+			name:           "type sniffed",
+			acceptEncoding: "gzip",
+			handler:        write("", http.StatusOK, "<html>"+large),
+			wantStatus:     http.StatusOK,
+			wantEncoding:   "gzip",
+			wantBody:       "<html>" + large,
+			wantType:       "text/html; charset=utf-8",
+		},
+		{
+			name:           "encoded by the handler",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "identity")
+				w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+				io.WriteString(w, large)
+			},
+			wantStatus:   http.StatusOK,
+			wantEncoding: "identity",
+			wantBody:     large,
+		},
+		{
+			name:           "partial content",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(large)-1, 2*len(large)))
+				w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, large)
+			},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   large,
+		},
+		{
+			name:           "no content",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:           "not modified",
+			acceptEncoding: "br",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"1"`)
+				w.WriteHeader(http.StatusNotModified)
+			},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:           "head",
+			method:         http.MethodHead,
+			acceptEncoding: "gzip",
+			handler:        write("application/json", http.StatusOK, large),
+			wantStatus:     http.StatusOK,
+			wantBody:       large,
+		},
+		{
+			name:           "nothing written",
+			acceptEncoding: "gzip",
+			handler:        func(w http.ResponseWriter, r *http.Request) {},
+			wantStatus:     http.StatusOK,
+		},
+		{
+			name:           "flushed below the minimum size",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				io.WriteString(w, "data: 1\n\n")
+				w.(http.Flusher).Flush()
+				io.WriteString(w, "data: 2\n\n")
+			},
+			wantStatus:   http.StatusOK,
+			wantEncoding: "gzip",
+			wantBody:     "data: 1\n\ndata: 2\n\n",
+		},
+	}
+
+	app := newCompressApp(1024)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, "/v1/movies", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			rr := httptest.NewRecorder()
+			app.compress(tt.handler).ServeHTTP(rr, r)
+			res := rr.Result()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if got := res.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if got := res.Header.Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want Accept-Encoding", got)
+			}
+
+			// the length of the uncompressed body must not be sent with the compressed one
+			if tt.wantEncoding == "gzip" || tt.wantEncoding == "br" {
+				if got := res.Header.Get("Content-Length"); got != "" {
+					t.Errorf("got Content-Length %s on a compressed response", got)
+				}
+			} else if tt.wantBody != "" {
+				if got := res.Header.Get("Content-Length"); got != strconv.Itoa(len(tt.wantBody)) {
+					t.Errorf("got Content-Length %q, want %d", got, len(tt.wantBody))
+				}
+			}
+
+			if tt.wantType != "" {
+				if got := res.Header.Get("Content-Type"); got != tt.wantType {
+					t.Errorf("got Content-Type %q, want %q", got, tt.wantType)
+				}
+			}
+
+			if got := decodeBody(t, res); got != tt.wantBody {
+				t.Errorf("got body %.40q (%d bytes), want %.40q (%d bytes)", got, len(got), tt.wantBody, len(tt.wantBody))
+			}
+		})
+	}
+}
+
+func TestCompressDisabled(t *testing.T) {
+	app := newCompressApp(0)
+	app.config.compression.enabled = false
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Accept-Encoding", "gzip, br")
+
+	rr := httptest.NewRecorder()
+	app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})).ServeHTTP(rr, r)
+
+	if got := rr.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q, want none", got)
+	}
+	if got := rr.Header().Get("Vary"); got != "" {
+		t.Errorf("got Vary %q, want none", got)
+	}
+	if got := rr.Body.String(); got != "hello" {
+		t.Errorf("got body %q, want hello", got)
+	}
+}
+
+// the encoders are pooled, a response must never carry state (buffered bytes, the writer) of a previous one
+func TestCompressPooledEncodersReset(t *testing.T) {
+	app := newCompressApp(0)
+
+	for _, encoding := range []string{"gzip", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			for i := range 20 {
+				body := strings.Repeat(fmt.Sprintf("response %d ", i), 10*(i+1))
+
+				r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+				r.Header.Set("Accept-Encoding", encoding)
+
+				rr := httptest.NewRecorder()
+				app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "text/plain")
+					io.WriteString(w, body)
+				})).ServeHTTP(rr, r)
+
+				res := rr.Result()
+				if got := res.Header.Get("Content-Encoding"); got != encoding {
+					t.Fatalf("request %d: got Content-Encoding %q, want %q", i, got, encoding)
+				}
+				if got := decodeBody(t, res); got != body {
+					t.Fatalf("request %d: got body %.40q (%d bytes), want %.40q (%d bytes)", i, got, len(got), body, len(body))
+				}
+			}
+
+			enc := encoderPools[encoding].Get().(encoder)
+			defer encoderPools[encoding].Put(enc)
+
+			// a pooled encoder was reset to no writer, using it without Reset must not reach a previous response
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			enc.Write([]byte("fresh"))
+			enc.Close()
+
+			res := &http.Response{Header: http.Header{"Content-Encoding": {encoding}}, Body: io.NopCloser(&buf)}
+			if got := decodeBody(t, res); got != "fresh" {
+				t.Errorf("got body %q from a pooled encoder, want fresh", got)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   string
+	}{
+		{accept: nil, want: ""},
+		{accept: []string{"gzip"}, want: "gzip"},
+		{accept: []string{"x-gzip"}, want: "gzip"},
+		{accept: []string{"br"}, want: "br"},
+		{accept: []string{"gzip, br"}, want: "br"},
+		{accept: []string{"gzip", "br"}, want: "br"},
+		{accept: []string{"GZIP"}, want: "gzip"},
+		{accept: []string{"br;q=0.5, gzip;q=0.8"}, want: "gzip"},
+		{accept: []string{"br;q=0, gzip"}, want: "gzip"},
+		{accept: []string{"gzip;q=0"}, want: ""},
+		{accept: []string{"*"}, want: "br"},
+		{accept: []string{"*;q=0.5, br;q=0"}, want: "gzip"},
+		{accept: []string{"*;q=0"}, want: ""},
+		{accept: []string{"identity"}, want: ""},
+		{accept: []string{"deflate, zstd"}, want: ""},
+		{accept: []string{"gzip;q=invalid"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.accept, " "), func(t *testing.T) {
+			if got := negotiateEncoding(tt.accept); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
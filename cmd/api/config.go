@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/k1nho/letsgo/internal/clientip"
+	"github.com/k1nho/letsgo/internal/ratelimit"
+	"github.com/k1nho/letsgo/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix: every flag can be set through an environment variable, e.g -db-dsn through GREENLIGHT_DB_DSN
+const envPrefix = "GREENLIGHT_"
+
+// configControlFlags: flags that control how the configuration is loaded, they are not part of the configuration itself
+var configControlFlags = map[string]bool{"config": true, "print-config": true, "version": true}
+
+// secretFlags: values redacted by -print-config, the path of the TLS key tells where to look for it
+var secretFlags = map[string]bool{"smtp-password": true, "tls-key": true}
+
+// environmentDefaults: defaults of the flags that differ between environments, they apply when no source sets the flag.
+// HSTS is kept short outside of production so a broken certificate setup doesn't lock browsers out for a year
+var environmentDefaults = map[string]map[string]string{
+	"development": {"security-hsts-max-age": "0s"},
+	"staging":     {"security-hsts-max-age": "24h"},
+	"production":  {"security-hsts-max-age": "8760h"},
+}
+
+// stringList: a space separated list flag, unlike flag.Func it reports its value so -print-config can show it
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(val string) error {
+	*l = strings.Fields(val)
+	return nil
+}
+
+// limitList: a space separated list of name=rps:burst limits (e.g "auth=0.5:3 write=1:2")
+type limitList map[string]ratelimit.Limit
+
+func (l *limitList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(*l))
+	for name := range *l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		limit := (*l)[name]
+		names[i] = fmt.Sprintf("%s=%s:%d", name, strconv.FormatFloat(limit.RPS, 'f', -1, 64), limit.Burst)
+	}
+
+	return strings.Join(names, " ")
+}
+
+func (l *limitList) Set(val string) error {
+	limits := make(limitList)
+
+	for _, field := range strings.Fields(val) {
+		name, value, ok := strings.Cut(field, "=")
+		rps, burst, ok2 := strings.Cut(value, ":")
+		if !ok || !ok2 || name == "" {
+			return fmt.Errorf("%q must be name=rps:burst", field)
+		}
+
+		var limit ratelimit.Limit
+		var err error
+
+		limit.RPS, err = strconv.ParseFloat(rps, 64)
+		if err != nil || limit.RPS <= 0 {
+			return fmt.Errorf("%q: rps must be a positive number", field)
+		}
+
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return fmt.Errorf("%q: burst must be a positive integer", field)
+		}
+
+		limits[name] = limit
+	}
+
+	*l = limits
+	return nil
+}
+
+// factorList: a space separated list of name=factor multipliers (e.g "movies:write=2")
+type factorList map[string]float64
+
+func (l *factorList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(*l))
+	for name := range *l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		names[i] = name + "=" + strconv.FormatFloat((*l)[name], 'f', -1, 64)
+	}
+
+	return strings.Join(names, " ")
+}
+
+func (l *factorList) Set(val string) error {
+	factors := make(factorList)
+
+	for _, field := range strings.Fields(val) {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("%q must be name=factor", field)
+		}
+
+		factor, err := strconv.ParseFloat(value, 64)
+		if err != nil || factor <= 0 {
+			return fmt.Errorf("%q: factor must be a positive number", field)
+		}
+
+		factors[name] = factor
+	}
+
+	*l = factors
+	return nil
+}
+
+// parseConfig: parses the command line arguments and loads the config file and the environment under them
+func parseConfig(args []string, errorHandling flag.ErrorHandling) (config, configOptions, *flag.FlagSet, error) {
+	var cfg config
+	var opts configOptions
+
+	fs := newFlagSet(&cfg, &opts, errorHandling)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return config{}, configOptions{}, nil, err
+	}
+
+	// the version is displayed even when the rest of the configuration is broken
+	if opts.version {
+		return cfg, opts, fs, nil
+	}
+
+	err = loadConfig(fs, opts.file)
+	if err != nil {
+		return config{}, configOptions{}, nil, err
+	}
+
+	// settings whose default depends on the environment are filled in once every source had a chance to set the environment
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	err = setFlags(fs, environmentDefaults[cfg.env], set, cfg.env+" defaults")
+	if err != nil {
+		return config{}, configOptions{}, nil, err
+	}
+
+	return cfg, opts, fs, nil
+}
+
+// loadConfig: layers the configuration sources on top of the flag defaults, in order of increasing precedence:
+// the config file, the GREENLIGHT_* environment variables and finally the flags given on the command line.
+// It must be called after fs.Parse, the file and environment only set the flags that were not given explicitly
+func loadConfig(fs *flag.FlagSet, path string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		err = setFlags(fs, values, explicit, "config file "+path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return setFlags(fs, envValues(fs), explicit, "environment")
+}
+
+func setFlags(fs *flag.FlagSet, values map[string]string, explicit map[string]bool, source string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if fs.Lookup(name) == nil || configControlFlags[name] {
+			return fmt.Errorf("%s: unknown configuration key %q", source, name)
+		}
+
+		if explicit[name] {
+			continue
+		}
+
+		err := fs.Set(name, values[name])
+		if err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %w", source, values[name], name, err)
+		}
+	}
+
+	return nil
+}
+
+// envValues: returns the flag values found in the environment, variables with the prefix that don't match a flag are ignored
+// since the prefix is shared with other tools (migrate reads GREENLIGHT_DB_DSN too)
+func envValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if configControlFlags[f.Name] {
+			return
+		}
+
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if val, ok := os.LookupEnv(name); ok {
+			values[f.Name] = val
+		}
+	})
+
+	return values
+}
+
+// readConfigFile: reads a JSON, YAML or TOML file (picked by extension) into flag values.
+// Keys are flag names, nested objects are joined with dashes so {"db": {"max_open_conns": 25}} sets -db-max-open-conns,
+// and lists are joined with spaces like the list flags expect
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &doc)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &doc)
+	case ".toml":
+		err = toml.Unmarshal(content, &doc)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, use .json, .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+
+	err = flattenConfig(values, "", doc)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+func flattenConfig(values map[string]string, prefix string, doc map[string]any) error {
+	for key, val := range doc {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+
+		switch val := val.(type) {
+		case map[string]any:
+			err := flattenConfig(values, name, val)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(val))
+			for i := range val {
+				item, err := configScalar(val[i])
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				items[i] = item
+			}
+			values[name] = strings.Join(items, " ")
+		default:
+			item, err := configScalar(val)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			values[name] = item
+		}
+	}
+
+	return nil
+}
+
+func configScalar(val any) (string, error) {
+	switch val := val.(type) {
+	case string:
+		return val, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case uint64:
+		return strconv.FormatUint(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", val)
+	}
+}
+
+/* Validator Contraints
+   -- port: must be a valid TCP port
+   -- env: must be development, staging or production
+   -- db: dsn must be provided, pool sizes can't be negative and the idle time must be a duration
+   -- limiter: backend must be memory or postgres, rps and burst must be positive and route groups must exist when the limiter is enabled
+   -- cors: origins must be a scheme and a host with an optional *. subdomain wildcard (* alone is only a trusted origin),
+      methods and headers must be tokens and the max age can't be negative
+   -- security headers: the HSTS max age can't be negative and preloading requires subdomains and a max age of a year,
+      the referrer policy must be one of the standard ones
+   -- trusted proxies: must be IP addresses or CIDR ranges
+   -- smtp: port must be a valid TCP port, the sender must be an email address and a username requires a password
+   -- tls: cert and key go together, client certificates and the redirect listener require TLS
+   -- ratios (access log sampling, trace sampling): must be between 0 and 1
+   -- timeouts and sizes: must be positive (the compression min size can be 0)
+*/
+
+func validateConfig(cfg config) error {
+	v := validator.New()
+
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil, "db-max-idle-time", "must be a duration (e.g 15m)")
+
+	v.Check(validator.In(cfg.limiter.backend, "memory", "postgres"), "limiter-backend", "must be memory or postgres")
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be positive")
+		v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be positive")
+		for group := range cfg.limiter.routes {
+			v.Check(validator.In(group, rateLimitGroups...), "limiter-routes", "groups must be one of "+strings.Join(rateLimitGroups, ", "))
+		}
+	}
+
+	for _, proxy := range cfg.trustedProxies {
+		_, err := clientip.ParseProxy(proxy)
+		v.Check(err == nil, "trusted-proxies", proxy+" "+clientip.ErrInvalidProxy.Error())
+	}
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		v.Check(origin == "*" || validOriginPattern(origin), "cors-trusted-origins", origin+" must be * or an origin (e.g https://example.com or https://*.example.com)")
+	}
+	for _, origin := range cfg.cors.credentialsOrigins {
+		v.Check(validOriginPattern(origin), "cors-credentials-origins", origin+" must be an origin (e.g https://example.com or https://*.example.com)")
+	}
+	for _, method := range cfg.cors.allowedMethods {
+		v.Check(validator.Matches(method, tokenRX), "cors-allowed-methods", method+" is not a valid method")
+	}
+	for _, header := range cfg.cors.allowedHeaders {
+		v.Check(validator.Matches(header, tokenRX), "cors-allowed-headers", header+" is not a valid header name")
+	}
+	for _, header := range cfg.cors.exposedHeaders {
+		v.Check(validator.Matches(header, tokenRX), "cors-exposed-headers", header+" is not a valid header name")
+	}
+	v.Check(cfg.cors.maxAge >= 0, "cors-max-age", "must not be negative")
+
+	v.Check(cfg.compression.minSize >= 0, "compression-min-size", "must not be negative")
+
+	v.Check(cfg.security.hstsMaxAge >= 0, "security-hsts-max-age", "must not be negative")
+	v.Check(!cfg.security.hstsPreload || cfg.security.hstsIncludeSubdomains && cfg.security.hstsMaxAge >= 8760*time.Hour,
+		"security-hsts-preload", "requires security-hsts-include-subdomains and a max age of at least a year")
+	v.Check(validator.In(cfg.security.referrerPolicy, referrerPolicies...), "security-referrer-policy", "must be one of "+strings.Join(referrerPolicies, ", "))
+
+	v.Check(cfg.ipRules.refresh > 0, "ip-rules-refresh", "must be positive")
+
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	_, err = mail.ParseAddress(cfg.smtp.sender)
+	v.Check(err == nil, "smtp-sender", "must be an email address (e.g Greenlight <no-reply@example.com>)")
+	v.Check(cfg.smtp.username == "" || cfg.smtp.password != "", "smtp-password", "must be provided along with smtp-user")
+
+	v.Check(cfg.movies.maxTitleBytes > 0, "movies-max-title-bytes", "must be positive")
+	v.Check(cfg.movies.maxGenres > 0, "movies-max-genres", "must be positive")
+	v.Check(cfg.movies.statsTTL >= 0, "movies-stats-ttl", "must not be negative")
+
+	v.Check(cfg.accessLog.sampleRate >= 0 && cfg.accessLog.sampleRate <= 1, "accesslog-sample-rate", "must be between 0 and 1")
+
+	v.Check(cfg.storage.dir != "", "storage-dir", "must be provided")
+	v.Check(cfg.storage.maxUploadBytes > 0, "storage-max-upload-bytes", "must be positive")
+
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-key", "must be provided along with tls-cert")
+	v.Check(cfg.tls.clientCAFile == "" || cfg.tlsEnabled(), "tls-client-ca", "requires tls-cert and tls-key")
+	v.Check(!cfg.tls.requireClientCert || cfg.tls.clientCAFile != "", "tls-require-client-cert", "requires tls-client-ca")
+	if cfg.tls.redirectPort != 0 {
+		v.Check(cfg.tlsEnabled(), "tls-redirect-port", "requires tls-cert and tls-key")
+		v.Check(cfg.tls.redirectPort > 0 && cfg.tls.redirectPort <= 65535, "tls-redirect-port", "must be between 1 and 65535")
+		v.Check(cfg.tls.redirectPort != cfg.port, "tls-redirect-port", "must be different from port")
+	}
+	v.Check(cfg.tls.reloadInterval > 0, "tls-reload-interval", "must be positive")
+
+	v.Check(cfg.healthcheck.timeout > 0, "healthcheck-timeout", "must be positive")
+	v.Check(cfg.healthcheck.shutdownDelay >= 0, "healthcheck-shutdown-delay", "must not be negative")
+
+	v.Check(validator.In(cfg.tracing.exporter, "none", "stdout", "otlp"), "otel-exporter", "must be none, stdout or otlp")
+	if cfg.tracing.exporter == "otlp" {
+		u, err := url.Parse(cfg.tracing.endpoint)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "otel-endpoint", "must be an http or https URL")
+	}
+	v.Check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "otel-sample-ratio", "must be between 0 and 1")
+
+	if v.Valid() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, len(keys))
+	for i, key := range keys {
+		problems[i] = key + ": " + v.Errors[key]
+	}
+
+	return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+}
+
+// printConfig: writes the effective configuration as a flat JSON object that can be used as a config file, with secrets redacted
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	values := flagValues(fs)
+	for name, value := range values {
+		values[name] = redactConfigValue(name, value)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+
+	return enc.Encode(values)
+}
+
+// flagValues: returns the current value of every configuration flag, keyed by flag name
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if configControlFlags[f.Name] {
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+
+	return values
+}
+
+func redactConfigValue(name, value string) string {
+	if value == "" {
+		return value
+	}
+
+	if secretFlags[name] {
+		return "REDACTED"
+	}
+
+	// the DSN is printed without its password so the rest of it can still be checked
+	if name == "db-dsn" {
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" {
+			return "REDACTED"
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		return u.String()
+	}
+
+	return value
+}
@@ -9,9 +9,21 @@ import (
 
 type contextKey string
 
-const userContentKey = contextKey("user")
+const (
+	userContentKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+	accessLogContextKey = contextKey("access_log")
+	permissionsKey      = contextKey("permissions")
+	clientIPContextKey  = contextKey("client_ip")
+	formatContextKey    = contextKey("format")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// the access log middleware runs before authenticate, so it only learns about the user through its entry
+	if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok {
+		entry.user = user
+	}
+
 	ctx := context.WithValue(r.Context(), userContentKey, user)
 	return r.WithContext(ctx)
 }
@@ -23,3 +35,54 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID: unlike the user, the request id is optional so an empty string is returned when it is missing
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// contextSetPermissions: the rate limiter loads the permissions of the user to find its tier, they are kept so
+// requirePermission doesn't query them a second time
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsKey, permissions)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsKey).(data.Permissions)
+	return permissions, ok
+}
+
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIP: the address resolved by the resolveClientIP middleware, the peer address when it didn't run
+func (app *application) contextGetClientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func (app *application) contextSetFormat(r *http.Request, format *responseFormat) *http.Request {
+	ctx := context.WithValue(r.Context(), formatContextKey, format)
+	return r.WithContext(ctx)
+}
+
+// contextGetFormat: the format negotiated by the negotiate middleware, indented JSON when it didn't run
+func (app *application) contextGetFormat(r *http.Request) *responseFormat {
+	format, ok := r.Context().Value(formatContextKey).(*responseFormat)
+	if !ok {
+		return lookupFormat("pretty")
+	}
+	return format
+}
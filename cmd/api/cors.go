@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// tokenRX: HTTP methods and header names are tokens (RFC 9110)
+var tokenRX = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// trusts: the origin matches one of the trusted origins
+func (c corsConfig) trusts(origin string) bool {
+	return matchOrigins(c.trustedOrigins, origin)
+}
+
+// allowsCredentials: credentials are only allowed for trusted origins also matching one of the credentials origins
+func (c corsConfig) allowsCredentials(origin string) bool {
+	return c.trusts(origin) && matchOrigins(c.credentialsOrigins, origin)
+}
+
+func matchOrigins(patterns []string, origin string) bool {
+	for _, pattern := range patterns {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin: * matches every origin, a pattern with a *. host (https://*.example.com) matches the subdomains of any depth
+// with the same scheme and port but not the domain itself, any other pattern has to be equal to the origin
+func matchOrigin(pattern, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	scheme, host, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+
+	prefix := scheme + "://"
+	suffix := "." + host
+
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	// the part matched by * can only be made of host labels, so the pattern can't be satisfied with a userinfo or another port
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+	if subdomain == "" || strings.HasPrefix(subdomain, ".") {
+		return false
+	}
+
+	for _, c := range subdomain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validOriginPattern: a scheme and a host, optionally with a port and a leading *. label, but nothing else
+func validOriginPattern(pattern string) bool {
+	u, err := url.Parse(strings.Replace(pattern, "://*.", "://wildcard.", 1))
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme != "" && u.Host != "" && u.User == nil && u.Path == "" && u.RawQuery == "" && u.Fragment == "" &&
+		!strings.Contains(u.Host, "*")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	rpprof "runtime/pprof"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// pprofProfileHandler: Serves the runtime profile named in the path (heap, goroutine, allocs, block, mutex, threadcreate)
+func (app *application) pprofProfileHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("profile")
+
+	if rpprof.Lookup(name) == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	pprof.Handler(name).ServeHTTP(w, r)
+}
+
+// goroutineDumpHandler: Writes the stack trace of every goroutine as plain text, in the same format as an unrecovered panic
+func (app *application) goroutineDumpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	err := rpprof.Lookup("goroutine").WriteTo(w, 2)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,19 +3,139 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/k1nho/letsgo/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (app *application) logError(r *http.Request, err error) {
+	// the span of the request fails with the same error that is logged, so traces and logs can be matched
+	tracing.RecordError(trace.SpanFromContext(r.Context()), err)
+
 	app.logger.PrinfError(err, map[string]string{
+		"request_id":     app.contextGetRequestID(r),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+// Stable machine readable error codes, clients should branch on these instead of the English messages
+const (
+	codeServerError                = "server_error"
+	codeNotFound                   = "not_found"
+	codeMethodNotAllowed           = "method_not_allowed"
+	codeBadRequest                 = "bad_request"
+	codeUnsupportedMediaType       = "unsupported_media_type"
+	codeFailedValidation           = "failed_validation"
+	codeEditConflict               = "edit_conflict"
+	codeRateLimitExceeded          = "rate_limit_exceeded"
+	codeInvalidCredentials         = "invalid_credentials"
+	codeInvalidAuthenticationToken = "invalid_authentication_token"
+	codeAuthenticationRequired     = "authentication_required"
+	codeInactiveAccount            = "inactive_account"
+	codeNotPermitted               = "not_permitted"
+	codeAddressBlocked             = "address_blocked"
+	codeNotAcceptable              = "not_acceptable"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	problemTypeBaseURL = "https://greenlight.kinho.net/problems/"
+)
+
+// problem: RFC 7807 problem details object
+type problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail"`
+	Instance      string         `json:"instance"`
+	Code          string         `json:"code"`
+	RequestID     string         `json:"request_id,omitempty"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// wantsProblemJSON: problem details are sent when enabled for every client in the configuration, or when the client asks for them in Accept
+func (app *application) wantsProblemJSON(r *http.Request) bool {
+	if app.config.errors.problemJSON {
+		return true
+	}
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.TrimSpace(mediaType) == problemContentType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// errorResponse: sends the error as {"error": message}, or as a problem details object when the client negotiated it.
+// message is either a string or the validator.Errors map of a failed validation
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	if !app.config.errors.problemJSON {
+		addVary(w.Header(), "Accept")
+	}
+
+	if !app.wantsProblemJSON(r) {
+		// errors are not lists, a client negotiating a tabular format for a list route gets them as JSON
+		if app.contextGetFormat(r).tabular {
+			r = app.contextSetFormat(r, lookupFormat("json"))
+		}
+
+		env := envelope{"error": message}
+		if requestID := app.contextGetRequestID(r); requestID != "" {
+			env["request_id"] = requestID
+		}
+
+		err := app.writeResponse(w, r, status, env, nil)
+		if err != nil {
+			app.logError(r, err)
+			w.WriteHeader(500)
+		}
+		return
+	}
+
+	p := problem{
+		Type:      problemTypeBaseURL + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: app.contextGetRequestID(r),
+	}
+
+	switch message := message.(type) {
+	case map[string]string:
+		p.Detail = "the request contains invalid parameters"
+
+		names := make([]string, 0, len(message))
+		for name := range message {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			p.InvalidParams = append(p.InvalidParams, invalidParam{Name: name, Reason: message[name]})
+		}
+	default:
+		p.Detail = fmt.Sprint(message)
+	}
 
-	err := app.WriteJson(w, status, env, nil)
+	headers := make(http.Header)
+	headers.Set("Content-Type", problemContentType)
+
+	err := app.writeJSONValue(w, status, p, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -26,59 +146,76 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 	app.logError(r, err)
 
 	message := "The server encountered a problem and could not process the request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeServerError, message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "The requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("The method %s is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
 }
 
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeFailedValidation, errors)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the content type %q is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, message)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to edit the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict, message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeRateLimitExceeded, message)
+}
+
+func (app *application) addressBlockedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "requests from your network address are not allowed"
+	app.errorResponse(w, r, http.StatusForbidden, codeAddressBlocked, message)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	names, mediaTypes := supportedFormats(r)
+	message := fmt.Sprintf("the requested format is not available, supported media types are %s (or ?format= %s)",
+		strings.Join(mediaTypes, ", "), strings.Join(names, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, codeNotAcceptable, message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCredentials, message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidAuthenticationToken, message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthenticationRequired, message)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount, message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, message)
 }
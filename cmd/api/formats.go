@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/k1nho/letsgo/internal/render"
+)
+
+// responseFormat: a representation of the responses, selected with the Accept header or the ?format= query parameter
+type responseFormat struct {
+	name        string
+	contentType string
+	// mediaTypes: the media types of Accept selecting the format, the first one is the canonical one
+	mediaTypes []string
+	// tabular: the format can only represent lists, it is offered on the routes of tabularRoutes
+	tabular bool
+	encode  func(w io.Writer, data envelope) error
+}
+
+// responseFormats: the registry of formats, in order of preference when the client accepts several of them equally
+var responseFormats = []*responseFormat{
+	{
+		name:        "json",
+		contentType: "application/json",
+		// problem details are JSON as well, a client asking only for them must not get a 406
+		mediaTypes: []string{"application/json", problemContentType},
+		encode: func(w io.Writer, data envelope) error {
+			return json.NewEncoder(w).Encode(data)
+		},
+	},
+	{
+		name:        "pretty",
+		contentType: "application/json",
+		encode: func(w io.Writer, data envelope) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			return enc.Encode(data)
+		},
+	},
+	{
+		name:        "csv",
+		contentType: "text/csv; charset=utf-8",
+		mediaTypes:  []string{"text/csv"},
+		tabular:     true,
+		encode: renderFrom(func(w io.Writer, v render.Value) error {
+			return render.CSV(w, v)
+		}),
+	},
+	{
+		name:        "xml",
+		contentType: "application/xml; charset=utf-8",
+		mediaTypes:  []string{"application/xml", "text/xml"},
+		encode: renderFrom(func(w io.Writer, v render.Value) error {
+			return render.XML(w, "response", v)
+		}),
+	},
+	{
+		name:        "msgpack",
+		contentType: "application/msgpack",
+		mediaTypes:  []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode: renderFrom(func(w io.Writer, v render.Value) error {
+			return render.MessagePack(w, v)
+		}),
+	},
+}
+
+// renderFrom: formats other than JSON are rendered from the JSON of the envelope, so they follow the same json tags
+func renderFrom(fn func(w io.Writer, v render.Value) error) func(w io.Writer, data envelope) error {
+	return func(w io.Writer, data envelope) error {
+		js, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		v, err := render.Parse(js)
+		if err != nil {
+			return err
+		}
+
+		return fn(w, v)
+	}
+}
+
+// tabularRoutes: the paths answering GET with a list envelope, the only ones a tabular format is negotiated for
+var tabularRoutes = map[string]bool{
+	"/v1/movies":         true,
+	"/v1/genres":         true,
+	"/v1/admin/ip-rules": true,
+}
+
+func lookupFormat(name string) *responseFormat {
+	for _, f := range responseFormats {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// supportedFormats: the names and media types listed in 406 responses, tabular formats only for the routes offering them
+func supportedFormats(r *http.Request) (names, mediaTypes []string) {
+	for _, f := range responseFormats {
+		if f.tabular && !isTabularRoute(r) {
+			continue
+		}
+		names = append(names, f.name)
+		mediaTypes = append(mediaTypes, f.mediaTypes...)
+	}
+	return names, mediaTypes
+}
+
+// negotiateFormat: ?format= wins over Accept. Without either, or when JSON is only accepted through a wildcard (*/*
+// sent by browsers and curl), the indented JSON the API has always returned is used. A nil format means none is acceptable
+func negotiateFormat(r *http.Request) *responseFormat {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return lookupFormat(name)
+	}
+
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return lookupFormat("pretty")
+	}
+
+	var best *responseFormat
+	bestQ := 0.0
+	explicit := false
+
+	for _, f := range responseFormats {
+		for _, mediaType := range f.mediaTypes {
+			q, exact := acceptQuality(accept, mediaType)
+			if q > bestQ {
+				best, bestQ, explicit = f, q, exact
+			}
+		}
+	}
+
+	if best != nil && best.name == "json" && !explicit {
+		return lookupFormat("pretty")
+	}
+
+	return best
+}
+
+// acceptQuality: the quality value of the most specific media range matching the media type, and whether that range
+// named the media type rather than a wildcard
+func acceptQuality(accept []string, mediaType string) (float64, bool) {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	q := 0.0
+	specificity := -1
+
+	for _, value := range accept {
+		for _, mediaRange := range strings.Split(value, ",") {
+			name, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+
+			s := -1
+			switch {
+			case name == mediaType:
+				s = 2
+			case name == typ+"/*":
+				s = 1
+			case name == "*/*":
+				s = 0
+			}
+
+			if s <= specificity {
+				continue
+			}
+
+			rangeQ := 1.0
+			if params["q"] != "" {
+				rangeQ, err = strconv.ParseFloat(params["q"], 64)
+				if err != nil {
+					continue
+				}
+			}
+
+			q, specificity = rangeQ, s
+		}
+	}
+
+	return q, specificity == 2
+}
+
+// negotiate: picks the format of the API responses, rejecting the requests accepting none of them with a 406 before any
+// work is done. Routes not answering with envelopes (blobs, metrics and debug endpoints) are left alone
+func (app *application) negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/") || app.servesBlob(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addVary(w.Header(), "Accept")
+
+		format := negotiateFormat(r)
+		if format == nil || (format.tabular && !isTabularRoute(r)) {
+			app.notAcceptableResponse(w, r)
+			return
+		}
+
+		r = app.contextSetFormat(r, format)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isTabularRoute(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && tabularRoutes[strings.TrimSuffix(r.URL.Path, "/")]
+}
+
+// servesBlob: the path is under the base URL of a blob store mounted on this server, see routes
+func (app *application) servesBlob(path string) bool {
+	if _, ok := app.blobs.(http.Handler); !ok || !strings.HasPrefix(app.config.storage.baseURL, "/") {
+		return false
+	}
+
+	return strings.HasPrefix(path, strings.TrimSuffix(app.config.storage.baseURL, "/")+"/")
+}
+
+// writeResponse: writes the envelope in the format negotiated for the request
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	format := app.contextGetFormat(r)
+
+	var body bytes.Buffer
+
+	err := format.encode(&body, data)
+	if err != nil {
+		return err
+	}
+
+	for key, val := range headers {
+		w.Header()[key] = val
+	}
+
+	if headers.Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", format.contentType)
+	}
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+
+	return nil
+}
+
+// addVary: adds a header to Vary unless it is already listed
+func addVary(h http.Header, header string) {
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), header) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", header)
+}
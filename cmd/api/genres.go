@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/k1nho/letsgo/internal/data"
+)
+
+// movieRules: builds the movie validation rules from the configuration, loading the genre vocabulary through genres
+// when it is enabled (a model bound to the transaction of the caller, if any)
+func (app *application) movieRules(ctx context.Context, genres data.GenreModel) (data.MovieRules, error) {
+	rules := data.MovieRules{
+		MaxTitleBytes: app.config.movies.maxTitleBytes,
+		MinYear:       int32(app.config.movies.minYear),
+		MaxGenres:     app.config.movies.maxGenres,
+	}
+
+	if app.config.movies.genreVocabulary {
+		names, err := genres.GetAllNames(ctx)
+		if err != nil {
+			return data.MovieRules{}, err
+		}
+		rules.Genres = names
+	}
+
+	return rules, nil
+}
+
+// listGenresHandler: Returns the known genres along with the number of movies in each one (JSON)
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll(r.Context(), app.config.movies.genreVocabulary)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
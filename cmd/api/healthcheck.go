@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 )
 
-// healthcheckHandler: PING endpoint to check status
+// healthcheckHandler: PING endpoint to check status, kept for existing clients, it doesn't check dependencies (see readinessHandler)
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	envelope := envelope{
 		"status": "available",
@@ -14,10 +16,106 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		},
 	}
 
-	err := app.WriteJson(w, http.StatusOK, envelope, nil)
+	err := app.writeResponse(w, r, http.StatusOK, envelope, nil)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// livenessHandler: Reports that the process is up and serving requests, it never checks dependencies so a database outage
+// doesn't get the process restarted (JSON)
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type healthCheck struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type poolCheck struct {
+	OpenConnections    int     `json:"open_connections"`
+	MaxOpenConnections int     `json:"max_open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitDuration       string  `json:"wait_duration"`
+	Saturation         float64 `json:"saturation"`
+}
+
+// readinessHandler: Reports whether the server can take traffic, 503 when shutting down or when a required dependency is down (JSON)
+// The pool statistics are informative only, a saturated pool slows requests down but doesn't make the server unable to serve them
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ready := !app.shuttingDown.Load()
+
+	checks := envelope{}
+
+	// a server that is shutting down is not ready whatever the state of its dependencies, so they are not checked
+	if ready {
+		database := app.runHealthCheck(r.Context(), app.db.PingContext)
+		checks["database"] = database
+		ready = database.Status == "up"
+
+		if app.config.healthcheck.smtp {
+			smtp := app.runHealthCheck(r.Context(), app.live.Load().mailer.Ping)
+			checks["smtp"] = smtp
+			ready = ready && smtp.Status == "up"
+		}
+	}
+
+	stats := app.db.Stats()
+
+	pool := poolCheck{
+		OpenConnections:    stats.OpenConnections,
+		MaxOpenConnections: stats.MaxOpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration.String(),
+	}
+	// without a limit on open connections the pool can't saturate
+	if stats.MaxOpenConnections > 0 {
+		pool.Saturation = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	}
+	checks["database_pool"] = pool
+
+	env := envelope{"status": "ready", "checks": checks}
+	status := http.StatusOK
+
+	if !ready {
+		env["status"] = "not ready"
+		status = http.StatusServiceUnavailable
+	}
+
+	if app.shuttingDown.Load() {
+		env["status"] = "shutting down"
+	}
+
+	err := app.writeResponse(w, r, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runHealthCheck: runs a dependency check bounded by the configured healthcheck timeout
+func (app *application) runHealthCheck(ctx context.Context, check func(ctx context.Context) error) healthCheck {
+	ctx, cancel := context.WithTimeout(ctx, app.config.healthcheck.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+
+	result := healthCheck{Status: "up", Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = "down"
+		result.Error = err.Error()
+	}
+
+	return result
+}
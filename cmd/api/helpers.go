@@ -1,21 +1,30 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/k1nho/letsgo/internal/jsonpatch"
 	"github.com/k1nho/letsgo/internal/validator"
 )
 
 type envelope map[string]any
 
+// 1MB max
+const maxBodyBytes = 1_048_578
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
 func (app *application) readIDParam(w http.ResponseWriter, r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
@@ -27,7 +36,23 @@ func (app *application) readIDParam(w http.ResponseWriter, r *http.Request) (int
 	return id, nil
 }
 
-func (app *application) WriteJson(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+// paramSwitch: dispatches to the handler registered for the value of a route param, or to next for any other value
+func (app *application) paramSwitch(param string, handlers map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName(param)]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// writeJSONValue: writes any value as the JSON body whatever the negotiated format (problem details are JSON by definition),
+// the Content-Type defaults to application/json unless set in headers
+func (app *application) writeJSONValue(w http.ResponseWriter, status int, data any, headers http.Header) error {
 	// MarshalIndent is also to possible to pretiffy the JSON but it comes at a cost of two more heap allocation
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -39,7 +64,9 @@ func (app *application) WriteJson(w http.ResponseWriter, status int, data envelo
 		w.Header()[key] = val
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if headers.Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	w.Write(js)
 
@@ -47,10 +74,14 @@ func (app *application) WriteJson(w http.ResponseWriter, status int, data envelo
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error { // Decode the request body into the target destination.
-	// 1MB max
-	maxBytes := 1_048_578
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	dec := json.NewDecoder(r.Body)
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBodyBytes))
+
+	return decodeJSON(r.Body, dst)
+}
+
+// decodeJSON: decodes a single JSON value from src into dst, rejecting unknown keys and translating the decoder errors into client friendly messages
+func decodeJSON(src io.Reader, dst interface{}) error {
+	dec := json.NewDecoder(src)
 	dec.DisallowUnknownFields()
 	err := dec.Decode(dst)
 
@@ -75,7 +106,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
 			return fmt.Errorf("body contains unknown key: %s", fieldName)
 		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			return fmt.Errorf("body must not be larger than %d bytes", maxBodyBytes)
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
 		default:
@@ -90,6 +121,62 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// requestMediaType: returns the media type of the request body without its parameters, an empty body type is treated as application/json
+func (app *application) requestMediaType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return "application/json"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+
+	return mediaType
+}
+
+// applyPatch: applies the merge patch (RFC 7396) or JSON patch (RFC 6902) read from src to the JSON representation of dst,
+// and decodes the result back into a zeroed dst, so members removed by the patch end up with their zero value
+func applyPatch(mediaType string, src io.Reader, dst interface{}) error {
+	patch, err := io.ReadAll(src)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBodyBytes)
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	current, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case jsonpatch.MergePatchType:
+		patched, err = jsonpatch.MergePatch(current, patch)
+	case jsonpatch.JSONPatchType:
+		patched, err = jsonpatch.Apply(current, patch)
+	default:
+		return errUnsupportedMediaType
+	}
+
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	v.Set(reflect.Zero(v.Type()))
+
+	return decodeJSON(bytes.NewReader(patched), dst)
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/k1nho/letsgo/internal/blob"
+	"github.com/k1nho/letsgo/internal/data"
+	"github.com/k1nho/letsgo/internal/imaging"
+	"github.com/k1nho/letsgo/internal/validator"
+)
+
+const (
+	thumbnailWidth  = 320
+	thumbnailHeight = 320
+)
+
+// imageExtensions: the sniffed content types accepted for upload and the extension they are stored with
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// uploadMovieImageHandler: upload a poster or still for a movie given id in path (multipart/form-data with image and kind fields)
+func (app *application) uploadMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(w, r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	content, kind, err := app.readImageUpload(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// never trust the client provided content type, sniff it from the first bytes of the file instead
+	contentType := http.DetectContentType(content)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("image could not be decoded: %w", err))
+		return
+	}
+
+	img := &data.MovieImage{
+		MovieID:     movie.ID,
+		Kind:        kind,
+		ContentType: contentType,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		Size:        int64(len(content)),
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieImage(v, img); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// decoding is only done once the dimensions are known to be sane, so a tiny file can't claim a huge canvas
+	decoded, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("image could not be decoded: %w", err))
+		return
+	}
+
+	thumbnail := new(bytes.Buffer)
+	err = encodeImage(thumbnail, contentType, imaging.Thumbnail(decoded, thumbnailWidth, thumbnailHeight))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	name, err := randomName()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	img.BlobKey = fmt.Sprintf("movies/%d/%s%s", movie.ID, name, ext)
+	img.ThumbnailKey = fmt.Sprintf("movies/%d/%s_thumb%s", movie.ID, name, ext)
+	img.URL = app.blobs.URL(img.BlobKey)
+	img.ThumbnailURL = app.blobs.URL(img.ThumbnailKey)
+
+	err = app.blobs.Put(r.Context(), img.BlobKey, bytes.NewReader(content))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.blobs.Put(r.Context(), img.ThumbnailKey, thumbnail)
+	if err != nil {
+		app.blobs.Delete(r.Context(), img.BlobKey)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MovieImages.Insert(r.Context(), img)
+	if err != nil {
+		app.blobs.Delete(r.Context(), img.BlobKey)
+		app.blobs.Delete(r.Context(), img.ThumbnailKey)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", img.URL)
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"image": img}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readImageUpload: reads the image file and its kind from a multipart body, the whole body is capped at the configured upload size
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request) ([]byte, string, error) {
+	maxBytes := app.config.storage.maxUploadBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	// anything past 1MB is buffered to temporary files instead of memory
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		switch {
+		case err.Error() == "http: request body too large":
+			return nil, "", fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		case errors.Is(err, http.ErrNotMultipart):
+			return nil, "", errors.New("body must be multipart/form-data")
+		default:
+			return nil, "", err
+		}
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, "", errors.New("body must contain an image file")
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	kind := app.readString(r.MultipartForm.Value, "kind", data.ImageKindPoster)
+
+	return content, kind, nil
+}
+
+func encodeImage(w io.Writer, contentType string, img image.Image) error {
+	switch contentType {
+	case "image/png":
+		return png.Encode(w, img)
+	case "image/gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+}
+
+func randomName() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// deleteBlobs: deletes the blobs in the background, a blob that can't be deleted is only logged as the rows referencing
+// it are already gone
+func (app *application) deleteBlobs(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		for _, key := range keys {
+			err := app.blobs.Delete(ctx, key)
+			if err != nil && !errors.Is(err, blob.ErrNotFound) {
+				app.logger.PrinfError(err, map[string]string{"blob_key": key})
+			}
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/k1nho/letsgo/internal/data"
+	"github.com/k1nho/letsgo/internal/iprules"
+	"github.com/k1nho/letsgo/internal/jsonlog"
+	"github.com/k1nho/letsgo/internal/validator"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ipRulesPath: the admin routes managing the IP rules, see routes
+const ipRulesPath = "/v1/admin/ip-rules"
+
+// loadIPRules: builds a new tree from the rules of the database and swaps it in, requests in flight keep the tree they loaded
+func (app *application) loadIPRules(ctx context.Context) error {
+	rules, err := app.models.IPRules.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]iprules.Rule, 0, len(rules))
+	for _, rule := range rules {
+		prefix, err := iprules.ParsePrefix(rule.CIDR)
+		if err != nil {
+			return fmt.Errorf("ip rule %d: %s %w", rule.ID, rule.CIDR, err)
+		}
+
+		entries = append(entries, iprules.Rule{ID: rule.ID, Prefix: prefix, Action: rule.Action})
+	}
+
+	app.ipRules.Store(iprules.NewTree(entries))
+
+	return nil
+}
+
+// refreshIPRules: reloads the rules every -ip-rules-refresh until the process exits, changes made through this server are
+// applied right away but the ones made through other replicas are only seen here
+func (app *application) refreshIPRules() {
+	for {
+		time.Sleep(app.config.ipRules.refresh)
+
+		err := app.loadIPRules(context.Background())
+		if err != nil {
+			// the previous rules stay in place until the database can be reached again
+			app.logger.Error(err, jsonlog.Properties{"action": "ip rules refresh"})
+		}
+	}
+}
+
+// filterIP: rejects the clients whose address is denied by the IP rules. It runs before authenticate and rateLimit
+// so blocked networks don't cost a database query or a rate limiter bucket. The IP rules admin routes are exempt, they
+// still require the ip_rules permissions and a rule denying the admins' own addresses can be undone through them
+func (app *application) filterIP(next http.Handler) http.Handler {
+	totalRequestsBlocked := expvar.NewInt("total_requests_blocked")
+
+	// labeled by the rule id rather than its range, ranges can be deleted and created again with another action
+	blockedTotal := promauto.With(app.prometheus).NewCounterVec(prometheus.CounterOpts{
+		Name: "greenlight_ip_blocked_requests_total",
+		Help: "Total number of requests rejected by the IP rules.",
+	}, []string{"rule"})
+	promauto.With(app.prometheus).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "greenlight_ip_rules",
+		Help: "Number of IP allow and deny rules loaded.",
+	}, func() float64 {
+		return float64(app.ipRules.Load().Len())
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == ipRulesPath || strings.HasPrefix(r.URL.Path, ipRulesPath+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, err := netip.ParseAddr(app.contextGetClientIP(r))
+		if err == nil {
+			rule, allowed := app.ipRules.Load().Allowed(addr)
+			if !allowed {
+				totalRequestsBlocked.Add(1)
+				blockedTotal.WithLabelValues(fmt.Sprint(rule.ID)).Inc()
+
+				app.addressBlockedResponse(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listIPRulesHandler: Returns every IP allow and deny rule, most specific ranges first (JSON)
+func (app *application) listIPRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.models.IPRules.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"ip_rules": rules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createIPRuleHandler: Allows or denies an IP address or CIDR range, the most specific range holding an address decides (JSON)
+func (app *application) createIPRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CIDR   string `json:"cidr"`
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rule := &data.IPRule{
+		CIDR:   input.CIDR,
+		Action: input.Action,
+		Note:   input.Note,
+	}
+
+	v := validator.New()
+
+	if data.ValidateIPRule(v, rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// stored as a range so 10.0.0.1, 10.0.0.1/32 and ::ffff:10.0.0.1 are the same rule
+	prefix, _ := iprules.ParsePrefix(rule.CIDR)
+	rule.CIDR = prefix.String()
+
+	err = app.models.IPRules.Insert(r.Context(), rule)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateIPRule):
+			v.AddError("cidr", "a rule for this range already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.ipRulesChanged(r, "ip rule created", rule)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/ip-rules/%d", rule.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"ip_rule": rule}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteIPRuleHandler: Removes an IP rule given its id in path (JSON)
+func (app *application) deleteIPRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(w, r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.IPRules.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.ipRulesChanged(r, "ip rule deleted", &data.IPRule{ID: id})
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "ip rule successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ipRulesChanged: applies a change to this server right away and records who made it. The change is already stored,
+// so a failed reload is only logged and left to the next refresh
+func (app *application) ipRulesChanged(r *http.Request, message string, rule *data.IPRule) {
+	app.logger.Warn(message, jsonlog.Properties{
+		"request_id": app.contextGetRequestID(r),
+		"user_id":    app.contextGetUser(r).ID,
+		"rule_id":    rule.ID,
+		"cidr":       rule.CIDR,
+		"action":     rule.Action,
+	})
+
+	err := app.loadIPRules(r.Context())
+	if err != nil {
+		app.logError(r, err)
+	}
+}
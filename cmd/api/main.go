@@ -6,16 +6,24 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/k1nho/letsgo/internal/blob"
+	"github.com/k1nho/letsgo/internal/clientip"
 	"github.com/k1nho/letsgo/internal/data"
+	"github.com/k1nho/letsgo/internal/iprules"
 	"github.com/k1nho/letsgo/internal/jsonlog"
-	"github.com/k1nho/letsgo/internal/mailer"
+	"github.com/k1nho/letsgo/internal/ratelimit"
+	"github.com/k1nho/letsgo/internal/tracing"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/otel"
 )
 
 var (
@@ -33,75 +41,304 @@ type config struct {
 		maxIdleTime  string
 	}
 
-	limiter struct {
-		rps     float64
-		burst   int
+	limiter limiterConfig
+	smtp    smtpConfig
+	cors    corsConfig
+
+	// trustedProxies: addresses and CIDR ranges of the proxies allowed to report the client address
+	trustedProxies []string
+
+	ipRules struct {
+		refresh time.Duration
+	}
+
+	compression struct {
 		enabled bool
+		minSize int
 	}
 
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
+	security struct {
+		hstsMaxAge            time.Duration
+		hstsIncludeSubdomains bool
+		hstsPreload           bool
+		csp                   string
+		referrerPolicy        string
 	}
 
-	cors struct {
-		trustedOrigins []string
+	movies struct {
+		maxTitleBytes   int
+		minYear         int
+		maxGenres       int
+		genreVocabulary bool
+		statsTTL        time.Duration
 	}
+
+	log struct {
+		level  jsonlog.Level
+		traces bool
+	}
+
+	accessLog struct {
+		enabled    bool
+		sampleRate float64
+		exclude    []string
+	}
+
+	errors struct {
+		problemJSON bool
+	}
+
+	storage struct {
+		dir            string
+		baseURL        string
+		maxUploadBytes int64
+	}
+
+	healthcheck struct {
+		timeout       time.Duration
+		smtp          bool
+		shutdownDelay time.Duration
+	}
+
+	tls struct {
+		certFile          string
+		keyFile           string
+		clientCAFile      string
+		requireClientCert bool
+		redirectPort      int
+		reloadInterval    time.Duration
+	}
+
+	tracing struct {
+		exporter    string
+		endpoint    string
+		serviceName string
+		sampleRatio float64
+	}
+}
+
+// tlsEnabled: the server listens for HTTPS instead of plain HTTP when a certificate is configured
+func (c config) tlsEnabled() bool {
+	return c.tls.certFile != ""
+}
+
+type limiterConfig struct {
+	rps     float64
+	burst   int
+	enabled bool
+	backend string
+	// routes: limits of the route groups (auth, read, write), groups without one use rps and burst
+	routes limitList
+	// tiers: multipliers of the limits for users holding a permission, the largest one applies
+	tiers factorList
+}
+
+type smtpConfig struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   string
+}
+
+type corsConfig struct {
+	trustedOrigins     []string
+	credentialsOrigins []string
+	allowedMethods     []string
+	allowedHeaders     []string
+	exposedHeaders     []string
+	maxAge             time.Duration
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config     config
+	logger     *jsonlog.Logger
+	db         *sql.DB
+	models     data.Models
+	blobs      blob.Store
+	prometheus *prometheus.Registry
+	statsCache *statsCache
+	limiter    ratelimit.RateLimiter
+	clientIP   *clientip.Resolver
+	wg         sync.WaitGroup
+	// shuttingDown: set as soon as the graceful shutdown starts, so readiness probes take the server out of rotation
+	shuttingDown atomic.Bool
+	// ipRules: the allow and deny rules of the database, see iprules.go
+	ipRules atomic.Pointer[iprules.Tree]
+	// live: the configuration reloaded on SIGHUP, see reload.go
+	live atomic.Pointer[liveConfig]
+	// configValues: the flag values the running configuration was loaded from, reloads are diffed against them
+	configValues map[string]string
+	reloadMu     sync.Mutex
 }
 
-func main() {
-	var cfg config
+// configOptions: flags that control how the configuration is loaded instead of configuring the server
+type configOptions struct {
+	file        string
+	printConfig bool
+	version     bool
+}
+
+// newFlagSet: declares every flag on a new flag set bound to cfg, so the configuration can be parsed again when reloading
+func newFlagSet(cfg *config, opts *configOptions, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
 
 	// read command line flag arguments to configure the server
-	flag.IntVar(&cfg.port, "port", 4000, "API Server Port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.IntVar(&cfg.port, "port", 4000, "API Server Port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
 
 	// DATABASE
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle time")
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle time")
 
 	// RATE LIMITER
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	cfg.limiter.routes = limitList{"auth": {RPS: 0.2, Burst: 3}}
+	fs.Var(&cfg.limiter.routes, "limiter-routes", "Rate limits of the route groups auth, read and write (space separated group=rps:burst), auth also limits invalid bearer tokens per address")
+	fs.Var(&cfg.limiter.tiers, "limiter-tiers", "Rate limit multipliers for users holding a permission (space separated permission=factor)")
+	fs.StringVar(&cfg.limiter.backend, "limiter-backend", "memory", "Where the rate limiter keeps its state (memory|postgres), postgres shares the limits between replicas")
 
 	// SMTP
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-user", "a7764aede43b48", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "bde3780ec22ae2", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.kinho.net>", "SMTP sender")
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-user", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.kinho.net>", "SMTP sender")
 
 	// TRUSTED ORIGINS
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
-
-	displayVersion := flag.Bool("version", false, "Display version and exit")
+	fs.Var((*stringList)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins, * matches any origin and https://*.example.com any subdomain (space separated)")
+	fs.Var((*stringList)(&cfg.cors.credentialsOrigins), "cors-credentials-origins", "Trusted origins allowed to send credentials (cookies, client certificates), same patterns except * (space separated)")
+	cfg.cors.allowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	fs.Var((*stringList)(&cfg.cors.allowedMethods), "cors-allowed-methods", "Methods allowed in cross origin requests (space separated)")
+	cfg.cors.allowedHeaders = []string{"Authorization", "Content-Type"}
+	fs.Var((*stringList)(&cfg.cors.allowedHeaders), "cors-allowed-headers", "Request headers allowed in cross origin requests (space separated)")
+	cfg.cors.exposedHeaders = []string{"Location", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "Retry-After"}
+	fs.Var((*stringList)(&cfg.cors.exposedHeaders), "cors-exposed-headers", "Response headers readable by cross origin scripts (space separated)")
+	fs.DurationVar(&cfg.cors.maxAge, "cors-max-age", 10*time.Minute, "How long browsers may cache a preflight response (0 disables caching)")
+
+	// TRUSTED PROXIES
+	fs.Var((*stringList)(&cfg.trustedProxies), "trusted-proxies", "Proxies trusted to report the client address in Forwarded and X-Forwarded-For (space separated IPs or CIDRs)")
+
+	// COMPRESSION
+	fs.BoolVar(&cfg.compression.enabled, "compression-enabled", true, "Compress responses with brotli or gzip when the client accepts it")
+	fs.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Responses smaller than this many bytes are sent uncompressed")
+
+	// SECURITY HEADERS
+	fs.DurationVar(&cfg.security.hstsMaxAge, "security-hsts-max-age", 0, "Strict-Transport-Security max age, 0 disables HSTS (defaults to a year in production, a day in staging and 0 in development)")
+	fs.BoolVar(&cfg.security.hstsIncludeSubdomains, "security-hsts-include-subdomains", false, "Apply HSTS to every subdomain")
+	fs.BoolVar(&cfg.security.hstsPreload, "security-hsts-preload", false, "Allow browsers to ship the domain in their HSTS preload lists")
+	fs.StringVar(&cfg.security.csp, "security-csp", "default-src 'none'; frame-ancestors 'none'", "Content-Security-Policy of the responses (empty disables it)")
+	fs.StringVar(&cfg.security.referrerPolicy, "security-referrer-policy", "no-referrer", "Referrer-Policy of the responses")
+
+	// IP RULES
+	fs.DurationVar(&cfg.ipRules.refresh, "ip-rules-refresh", 30*time.Second, "How often the IP allow and deny rules are reloaded from the database, picking up changes made on other replicas")
+
+	// MOVIE VALIDATION
+	defaultRules := data.DefaultMovieRules()
+	fs.IntVar(&cfg.movies.maxTitleBytes, "movies-max-title-bytes", defaultRules.MaxTitleBytes, "Maximum length of a movie title in bytes")
+	fs.IntVar(&cfg.movies.minYear, "movies-min-year", int(defaultRules.MinYear), "Earliest accepted movie release year")
+	fs.IntVar(&cfg.movies.maxGenres, "movies-max-genres", defaultRules.MaxGenres, "Maximum number of genres per movie")
+	fs.BoolVar(&cfg.movies.genreVocabulary, "movies-genre-vocabulary", false, "Only accept genres present in the genres table")
+	fs.DurationVar(&cfg.movies.statsTTL, "movies-stats-ttl", 30*time.Second, "How long movie statistics are cached")
+
+	// LOGGING
+	fs.TextVar(&cfg.log.level, "log-level", jsonlog.LevelInfo, "Minimum log level (debug|info|warn|error|fatal|off)")
+	fs.BoolVar(&cfg.log.traces, "log-stack-traces", false, "Include stack traces in error log entries")
+
+	// ACCESS LOG
+	cfg.accessLog.exclude = []string{"/v1/healthcheck"}
+	fs.BoolVar(&cfg.accessLog.enabled, "accesslog-enabled", true, "Enable the access log")
+	fs.Float64Var(&cfg.accessLog.sampleRate, "accesslog-sample-rate", 1, "Fraction of requests written to the access log (server errors are always written)")
+	fs.Var((*stringList)(&cfg.accessLog.exclude), "accesslog-exclude", "Paths excluded from the access log (space separated)")
+
+	// ERRORS
+	fs.BoolVar(&cfg.errors.problemJSON, "errors-problem-json", false, "Always send errors as application/problem+json (RFC 7807), otherwise only when requested in Accept")
+
+	// STORAGE
+	fs.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory where uploaded images are stored")
+	fs.StringVar(&cfg.storage.baseURL, "storage-base-url", "/v1/images", "Base URL uploaded images are served from, a path is served by this server to users with movies:read")
+	fs.Int64Var(&cfg.storage.maxUploadBytes, "storage-max-upload-bytes", 10<<20, "Maximum size of an uploaded image in bytes")
+
+	// TLS
+	fs.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file (PEM), enables HTTPS along with -tls-key")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file (PEM)")
+	fs.StringVar(&cfg.tls.clientCAFile, "tls-client-ca", "", "CA bundle (PEM) used to verify client certificates, enables mTLS for the clients presenting one")
+	fs.BoolVar(&cfg.tls.requireClientCert, "tls-require-client-cert", false, "Reject clients without a certificate signed by -tls-client-ca")
+	fs.IntVar(&cfg.tls.redirectPort, "tls-redirect-port", 0, "Port of a plain HTTP listener redirecting to HTTPS (0 disables it)")
+	fs.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", time.Minute, "How often the certificate files are checked for changes")
+
+	// HEALTHCHECK
+	fs.DurationVar(&cfg.healthcheck.timeout, "healthcheck-timeout", 2*time.Second, "Timeout of each dependency check of the readiness probe")
+	fs.BoolVar(&cfg.healthcheck.smtp, "healthcheck-smtp", false, "Include SMTP server reachability in the readiness probe")
+	fs.DurationVar(&cfg.healthcheck.shutdownDelay, "healthcheck-shutdown-delay", 0, "How long the server keeps serving while reporting not ready before shutting down")
+
+	// TRACING
+	fs.StringVar(&cfg.tracing.exporter, "otel-exporter", "none", "Trace exporter (none|stdout|otlp)")
+	fs.StringVar(&cfg.tracing.endpoint, "otel-endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint")
+	fs.StringVar(&cfg.tracing.serviceName, "otel-service-name", "greenlight", "Service name reported in the traces")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "otel-sample-ratio", 1, "Fraction of new traces that are sampled (incoming traceparent sampling decisions are kept)")
+
+	fs.StringVar(&opts.file, "config", os.Getenv(envPrefix+"CONFIG"), "Config file (.json, .yaml or .toml), overridden by GREENLIGHT_* environment variables and flags")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "Display the effective configuration with secrets redacted and exit")
+	fs.BoolVar(&opts.version, "version", false, "Display version and exit")
+
+	return fs
+}
 
-	flag.Parse()
+func main() {
+	cfg, opts, fs, err := parseConfig(os.Args[1:], flag.ExitOnError)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	if *displayVersion {
+	if opts.version {
 		fmt.Printf("Version:\t%s\n", version)
 		fmt.Printf("Build time:\t%s\n", buildTime)
 		os.Exit(0)
 	}
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	if opts.printConfig {
+		err = printConfig(os.Stdout, fs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// libraries logging through log/slog (and the standard log package) share the JSON stream with the application
+	slog.SetDefault(slog.New(jsonlog.NewHandler(os.Stdout, &jsonlog.HandlerOptions{
+		Level:  cfg.log.level,
+		Traces: cfg.log.traces,
+	})))
+
+	logger := jsonlog.FromSlog(slog.Default())
+
+	err = validateConfig(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// export failures are reported by the SDK in the background, they are logged instead of failing requests
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Error(err, jsonlog.Properties{"exporter": cfg.tracing.exporter, "endpoint": cfg.tracing.endpoint})
+	}))
+
+	err = tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.tracing.exporter,
+		Endpoint:    cfg.tracing.endpoint,
+		ServiceName: cfg.tracing.serviceName,
+		SampleRatio: cfg.tracing.sampleRatio,
+		Stdout:      os.Stdout,
+	})
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
 	// establish connection with DB
 	db, err := OpenDB(cfg)
@@ -112,6 +349,11 @@ func main() {
 
 	logger.PrinfInfo("Database connection pool established", nil)
 
+	blobs, err := blob.NewLocal(cfg.storage.dir, cfg.storage.baseURL)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// Metrics
 	expvar.NewString("version").Set(version)
 
@@ -127,11 +369,40 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewDBStatsCollector(db, "greenlight"))
+
+	// clients idle for 3 minutes have a full bucket again, so forgetting them doesn't change any decision
+	var limiter ratelimit.RateLimiter = ratelimit.NewMemory(3 * time.Minute)
+	if cfg.limiter.backend == "postgres" {
+		limiter = ratelimit.NewPostgres(db, 3*time.Minute, func(err error) {
+			logger.Error(err, jsonlog.Properties{"action": "rate limiter cleanup"})
+		})
+	}
+
+	clientIP, err := clientip.NewResolver(cfg.trustedProxies)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:       cfg,
+		logger:       logger,
+		db:           db,
+		models:       data.NewModels(db),
+		blobs:        blobs,
+		prometheus:   registry,
+		statsCache:   newStatsCache(cfg.movies.statsTTL),
+		limiter:      limiter,
+		clientIP:     clientIP,
+		configValues: flagValues(fs),
+	}
+
+	app.live.Store(newLiveConfig(cfg))
+
+	err = app.loadIPRules(context.Background())
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
 	err = app.serve()
@@ -1,22 +1,67 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
+	"math"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
 	"github.com/k1nho/letsgo/internal/data"
+	"github.com/k1nho/letsgo/internal/ratelimit"
+	"github.com/k1nho/letsgo/internal/tracing"
 	"github.com/k1nho/letsgo/internal/validator"
-	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// requestIDRX: client provided request ids are limited to a safe charset so they can't inject anything into logs or headers
+var requestIDRX = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// requestID: reuses the X-Request-ID sent by the client (or a proxy in front of us) when it looks sane, otherwise generates a new one.
+// The id is stored in the request context and echoed back in the response headers
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validator.Matches(id, requestIDRX) {
+			b := make([]byte, 16)
+
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolveClientIP: stores the address of the client in the request context, forwarding headers are only believed
+// when they were set by the trusted proxies (-trusted-proxies)
+func (app *application) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetClientIP(r, app.clientIP.Resolve(r))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -48,56 +93,76 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 */
 
-// IP-BASED Rate limiter
-func (app *application) rateLimit(next http.Handler) http.Handler {
-
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+// rateLimitGroups: every route belongs to one group, each group has its own bucket and limit (-limiter-routes)
+var rateLimitGroups = []string{"auth", "read", "write"}
+
+// rateLimitGroup: auth covers the routes taking credentials or tokens from anonymous clients (registration, activation, login),
+// the rest are split between reads and writes by method
+func rateLimitGroup(r *http.Request) string {
+	switch {
+	case r.Method == http.MethodPost && (r.URL.Path == "/v1/users" || r.URL.Path == "/v1/tokens/authentication"),
+		r.Method == http.MethodPut && r.URL.Path == "/v1/users/activated":
+		return "auth"
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return "read"
+	default:
+		return "write"
 	}
+}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	// This goroutine spins up every minute and cleans up clients that have not used the API within 3 minutes
-	// This allows our clients map to ever grow infinitely
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
+// groupLimit: the limit of a route group, the default limit when -limiter-routes doesn't set one
+func (l limiterConfig) groupLimit(group string) ratelimit.Limit {
+	limit, ok := l.routes[group]
+	if !ok {
+		limit = ratelimit.Limit{RPS: l.rps, Burst: l.burst}
+	}
+	return limit
+}
 
+// Rate limiter keyed by the authenticated user (by IP for anonymous clients) and route group, the buckets are kept by
+// app.limiter (in memory or shared between replicas, see -limiter-backend). It runs after authenticate to know the user,
+// so it never sees requests with an invalid token: authenticate charges those to the auth bucket of the client address
+func (app *application) rateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
+		limiter := app.live.Load().limiter
 
-			ip := realip.FromRequest(r)
+		if limiter.enabled {
+			group := rateLimitGroup(r)
+			limit := limiter.groupLimit(group)
 
-			mu.Lock()
+			user := app.contextGetUser(r)
+			key := group + ":ip:" + app.contextGetClientIP(r)
 
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
-			}
+			if !user.IsAnonymous() {
+				key = group + ":user:" + strconv.FormatInt(user.ID, 10)
 
-			clients[ip].lastSeen = time.Now()
+				if len(limiter.tiers) > 0 {
+					permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+					if err != nil {
+						app.serverErrorResponse(w, r, err)
+						return
+					}
+					r = app.contextSetPermissions(r, permissions)
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
+					limit = tierLimit(limit, limiter.tiers, permissions)
+				}
 			}
 
-			mu.Unlock()
-
+			res, err := app.limiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				// an unavailable limiter backend shouldn't take the whole API down with it, so the request goes through
+				app.logError(r, err)
+			} else {
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+
+				if !res.Allowed {
+					// whole seconds, rounded up so a client waiting that long finds a token
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+					app.rateLimitExceededResponse(w, r)
+					return
+				}
+			}
 		}
 
 		next.ServeHTTP(w, r)
@@ -105,6 +170,25 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 }
 
+// tierLimit: scales the limit by the largest multiplier of the permissions held, a scaled burst never drops below one request
+func tierLimit(limit ratelimit.Limit, tiers factorList, permissions data.Permissions) ratelimit.Limit {
+	factor := 0.0
+	for _, code := range permissions {
+		if tiers[code] > factor {
+			factor = tiers[code]
+		}
+	}
+
+	if factor == 0 {
+		return limit
+	}
+
+	return ratelimit.Limit{
+		RPS:   limit.RPS * factor,
+		Burst: max(1, int(float64(limit.Burst)*factor)),
+	}
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -132,10 +216,36 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopedAuthentication, token)
+		// tokens not found are charged to the auth bucket of the client address, so guessing tokens is limited like
+		// guessing passwords, and a client out of attempts is rejected before the token is looked up
+		limiter := app.live.Load().limiter
+		failureKey := "auth:ip:" + app.contextGetClientIP(r)
+		failureLimit := limiter.groupLimit("auth")
+
+		if limiter.enabled {
+			res, err := app.limiter.Peek(r.Context(), failureKey, failureLimit)
+			if err != nil {
+				app.logError(r, err)
+			} else if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				app.rateLimitExceededResponse(w, r)
+				return
+			}
+		}
+
+		ctx, span := tracing.Start(r.Context(), "authenticate")
+		user, err := app.models.Users.GetForToken(ctx, data.ScopedAuthentication, token)
+		span.End()
+
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
+				if limiter.enabled {
+					_, err = app.limiter.Allow(r.Context(), failureKey, failureLimit)
+					if err != nil {
+						app.logError(r, err)
+					}
+				}
 				app.invalidAuthenticationTokenResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -143,6 +253,8 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.Int64("enduser.id", user.ID))
+
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
@@ -182,10 +294,17 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			ctx, span := tracing.Start(r.Context(), "requirePermission", trace.WithAttributes(attribute.String("permission", code)))
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(ctx, user.ID)
+			span.End()
+
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		if !permissions.Include(code) {
@@ -199,45 +318,65 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
-// enableCors: Gets the origin from the header and if the origin is included in the trusted origins at configuration
+// enableCors: Gets the origin from the header and if the origin matches the trusted origins at configuration
 // Then, Access-Control-Allow-Origin header is included with the origin, so that the browser can display the information
 func (app *application) enableCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		// Very important! warns the caches that the response might be different
-		w.Header().Set("Vary", "Origin")
-
-		w.Header().Set("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
 
 		origin := r.Header.Get("Origin")
+		cors := app.live.Load().cors
 
-		if origin != "" && len(app.config.cors.trustedOrigins) > 0 {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
+		if origin != "" && cors.trusts(origin) {
+			// the origin is echoed back even when every origin is trusted, browsers refuse a * along with credentials
+			w.Header().Set("Access-Control-Allow-Origin", origin)
 
-					// Identify if it is a preflight request
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			if cors.allowsCredentials(origin) {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
 
-						w.WriteHeader(http.StatusOK)
-						return
-					}
+			// Identify if it is a preflight request
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(cors.allowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.allowedHeaders, ", "))
+
+				if cors.maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cors.maxAge.Seconds())))
 				}
+
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if len(cors.exposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(cors.exposedHeaders, ", "))
 			}
 		}
 		next.ServeHTTP(w, r)
 	})
-
 }
 
-func (app *application) metrics(next http.Handler) http.Handler {
+func (app *application) metrics(router *httprouter.Router, next http.Handler) http.Handler {
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
 	totalResponsesSent := expvar.NewInt("total_responses_sent")
 	totalProcessingTimeMicrosecond := expvar.NewInt("total_processing_time_μs")
 	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
 
+	// labeled by route pattern rather than path, so ids in the path don't create a series per movie
+	requestsTotal := promauto.With(app.prometheus).NewCounterVec(prometheus.CounterOpts{
+		Name: "greenlight_http_requests_total",
+		Help: "Total number of HTTP requests.",
+	}, []string{"route", "method", "status"})
+	requestDuration := promauto.With(app.prometheus).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "greenlight_http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		totalRequestsReceived.Add(1)
 
@@ -246,5 +385,29 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponsesSent.Add(1)
 		totalProcessingTimeMicrosecond.Add(metrics.Duration.Microseconds())
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+
+		route := app.routePattern(router, r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		status := strconv.Itoa(metrics.Code)
+
+		method := metricsMethod(r.Method)
+
+		requestsTotal.WithLabelValues(route, method, status).Inc()
+		requestDuration.WithLabelValues(route, method, status).Observe(metrics.Duration.Seconds())
 	})
 }
+
+// metricsMethod: the method is sent by the client, anything outside of the standard methods is counted as OTHER
+// so clients can't create series that the registry would keep forever
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
@@ -3,22 +3,30 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/k1nho/letsgo/internal/data"
+	"github.com/k1nho/letsgo/internal/jsonpatch"
 	"github.com/k1nho/letsgo/internal/validator"
 )
 
 // createMovieHandler: create a Movie given title, year, runtime, genres (JSON)
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-
-	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+	rules, err := app.movieRules(r.Context(), app.models.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
-	err := app.readJSON(w, r, &input)
+
+	app.createMovie(w, r, app.models.Movies, rules, http.MaxBytesReader(w, r.Body, int64(maxBodyBytes)))
+}
+
+// createMovie: creates the movie decoded from body through movies, shared by createMovieHandler and the batch operations
+func (app *application) createMovie(w http.ResponseWriter, r *http.Request, movies data.MovieModel, rules data.MovieRules, body io.Reader) {
+	var input movieFields
+
+	err := decodeJSON(body, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -33,12 +41,12 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, m); !v.Valid() {
+	if data.ValidateMovie(v, m, rules); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(m)
+	err = movies.Insert(r.Context(), m)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -47,7 +55,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", m.ID))
 
-	err = app.WriteJson(w, http.StatusCreated, envelope{"movie": m}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": m}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -62,7 +70,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -73,13 +81,31 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.WriteJson(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// updateMovieHandler: update a movie given an id in path (update: title, year, runtime, genres) (JSON)
+// movieFields: the editable fields of a movie, the body of a creation and the target document of merge and JSON patches
+type movieFields struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+// movieUpdate: a change to the editable fields of a movie, body is read in the format of mediaType. With version set
+// the update is conditional on the client having seen the latest copy
+type movieUpdate struct {
+	id        int64
+	version   *int32
+	mediaType string
+	body      io.Reader
+}
+
+// updateMovieHandler: update a movie given an id in path (update: title, year, runtime, genres)
+// Accepts application/json (partial update of the fields present), application/merge-patch+json (RFC 7396) and application/json-patch+json (RFC 6902)
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(w, r)
 	if err != nil {
@@ -87,7 +113,22 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	rules, err := app.movieRules(r.Context(), app.models.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.updateMovie(w, r, app.models.Movies, rules, movieUpdate{
+		id:        id,
+		mediaType: app.requestMediaType(r),
+		body:      http.MaxBytesReader(w, r.Body, int64(maxBodyBytes)),
+	})
+}
+
+// updateMovie: applies the update through movies, shared by updateMovieHandler and the batch operations
+func (app *application) updateMovie(w http.ResponseWriter, r *http.Request, movies data.MovieModel, rules data.MovieRules, update movieUpdate) {
+	movie, err := movies.Get(r.Context(), update.id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -98,42 +139,79 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	if update.version != nil && *update.version != movie.Version {
+		app.editConflictResponse(w, r)
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
+	switch update.mediaType {
+	case jsonpatch.MergePatchType, jsonpatch.JSONPatchType:
+		// the patch is applied to the editable fields only, so id, created_at and version cannot be patched
+		fields := movieFields{
+			Title:   movie.Title,
+			Year:    movie.Year,
+			Runtime: movie.Runtime,
+			Genres:  movie.Genres,
+		}
 
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
+		err = applyPatch(update.mediaType, update.body, &fields)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.editConflictResponse(w, r)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
 
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
+		movie.Title = fields.Title
+		movie.Year = fields.Year
+		movie.Runtime = fields.Runtime
+		movie.Genres = fields.Genres
+
+	case "application/json":
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+		err = decodeJSON(update.body, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-		movie.Genres = input.Genres
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+
+			movie.Genres = input.Genres
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, rules); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -144,7 +222,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.WriteJson(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -158,7 +236,21 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	blobKeys := app.deleteMovie(w, r, app.models.Movies, app.models.MovieImages, id)
+	app.deleteBlobs(blobKeys)
+}
+
+// deleteMovie: deletes the movie through movies, shared by deleteMovieHandler and the batch operations. The image rows go
+// with the movie (ON DELETE CASCADE), the keys of their blobs are returned for the caller to delete once the deletion is
+// committed, nil when the movie wasn't deleted
+func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request, movies data.MovieModel, images data.MovieImageModel, id int64) []string {
+	movieImages, err := images.GetAllForMovies(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	err = movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -166,13 +258,20 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
-		return
+		return nil
 	}
 
-	err = app.WriteJson(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	blobKeys := make([]string, 0, 2*len(movieImages[id]))
+	for _, img := range movieImages[id] {
+		blobKeys = append(blobKeys, img.BlobKey, img.ThumbnailKey)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+
+	return blobKeys
 }
 
 // listMoviesHandler: Returns a list of movies given some query params (title, genres, page, pageSize sort) (JSON)
@@ -199,13 +298,13 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.WriteJson(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
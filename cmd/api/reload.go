@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/k1nho/letsgo/internal/jsonlog"
+	"github.com/k1nho/letsgo/internal/mailer"
+)
+
+// liveConfig: the parts of the configuration that can change without a restart. It is never modified once stored,
+// a reload swaps in a new one so a request sees either the old or the new settings but never a mix of both
+type liveConfig struct {
+	limiter limiterConfig
+	cors    corsConfig
+	smtp    smtpConfig
+	mailer  mailer.Mailer
+}
+
+func newLiveConfig(cfg config) *liveConfig {
+	return &liveConfig{
+		limiter: cfg.limiter,
+		cors:    cfg.cors,
+		smtp:    cfg.smtp,
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+	}
+}
+
+// reloadableFlags: the flags applied by a reload, changes to any other flag are reported but need a restart
+var reloadableFlags = map[string]bool{
+	"limiter-rps":              true,
+	"limiter-burst":            true,
+	"limiter-enabled":          true,
+	"limiter-routes":           true,
+	"limiter-tiers":            true,
+	"cors-trusted-origins":     true,
+	"cors-credentials-origins": true,
+	"cors-allowed-methods":     true,
+	"cors-allowed-headers":     true,
+	"cors-exposed-headers":     true,
+	"cors-max-age":             true,
+	"log-level":                true,
+	"smtp-host":                true,
+	"smtp-port":                true,
+	"smtp-user":                true,
+	"smtp-password":            true,
+	"smtp-sender":              true,
+}
+
+// reloadOnSignal: reloads the configuration every time the process receives SIGHUP, until the process exits
+func (app *application) reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		err := app.reloadConfig()
+		if err != nil {
+			// the running configuration is kept as it is when the new one can't be loaded
+			app.logger.Error(err, jsonlog.Properties{"action": "configuration reload"})
+		}
+	}
+}
+
+// reloadConfig: loads the configuration again from the same command line, config file and environment,
+// swaps in the reloadable settings that changed and logs the difference with the running configuration
+func (app *application) reloadConfig() error {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	cfg, _, fs, err := parseConfig(os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		return err
+	}
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	values := flagValues(fs)
+
+	applied := jsonlog.Properties{}
+	var restartRequired []string
+
+	for name, value := range values {
+		previous := app.configValues[name]
+		if value == previous {
+			continue
+		}
+
+		if !reloadableFlags[name] {
+			restartRequired = append(restartRequired, name)
+			continue
+		}
+
+		applied[name] = jsonlog.Properties{
+			"from": redactConfigValue(name, previous),
+			"to":   redactConfigValue(name, value),
+		}
+		app.configValues[name] = value
+	}
+
+	if len(applied) > 0 {
+		app.live.Store(newLiveConfig(cfg))
+
+		// the level is only touched when the configuration changed it, so a level set through the admin API survives reloads
+		if _, ok := applied["log-level"]; ok {
+			app.logger.SetLevel(cfg.log.level)
+		}
+	}
+
+	app.logger.Info("configuration reloaded", jsonlog.Properties{"changed": applied})
+
+	if len(restartRequired) > 0 {
+		sort.Strings(restartRequired)
+		app.logger.Warn("configuration changes ignored until restart", jsonlog.Properties{"settings": strings.Join(restartRequired, " ")})
+	}
+
+	return nil
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"net/http/pprof"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *application) routes() http.Handler {
@@ -14,19 +17,60 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/live", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
 
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	// httprouter can't register static segments (batch, stats) next to the :id wildcard, so they are dispatched on the param value
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.requirePermission("movies:write", app.paramSwitch("id", map[string]http.HandlerFunc{
+		"batch": app.batchMoviesHandler,
+	}, app.notFoundResponse)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.paramSwitch("id", map[string]http.HandlerFunc{
+		"stats": app.movieStatsHandler,
+	}, app.showMovieHandler)))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/images", app.requirePermission("movies:write", app.uploadMovieImageHandler))
+
+	// stores that can serve their own blobs are mounted under the base URL when it is local to this server, the images
+	// are movie resources and need movies:read like the movies they belong to
+	if h, ok := app.blobs.(http.Handler); ok && strings.HasPrefix(app.config.storage.baseURL, "/") {
+		prefix := strings.TrimSuffix(app.config.storage.baseURL, "/")
+		router.HandlerFunc(http.MethodGet, prefix+"/*filepath", app.requirePermission("movies:read", http.StripPrefix(prefix, h).ServeHTTP))
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.RegisterUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	// the token is only sent once, the response must not be cached even though the request carries no Authorization header
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.withHeaders(map[string]string{"Cache-Control": "no-store"}, app.createAuthenticationTokenHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/log-level", app.requirePermission("logs:write", app.showLogLevelHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/log-level", app.requirePermission("logs:write", app.updateLogLevelHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/ip-rules", app.requirePermission("ip_rules:read", app.listIPRulesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/ip-rules", app.requirePermission("ip_rules:write", app.createIPRuleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/ip-rules/:id", app.requirePermission("ip_rules:write", app.deleteIPRuleHandler))
 
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// debug endpoints expose internals (pool stats, stacks, profiles) and are restricted to the debug:read permission
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requirePermission("debug:read", expvar.Handler().ServeHTTP))
+	router.HandlerFunc(http.MethodGet, "/debug/goroutines", app.requirePermission("debug:read", app.goroutineDumpHandler))
+	// the pprof index is an HTML page with an inline stylesheet
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/", app.requirePermission("debug:read", app.withHeaders(map[string]string{
+		"Content-Security-Policy": "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'",
+	}, pprof.Index)))
+	// profile and trace push the write deadline past WriteTimeout themselves, through the Unwrap of the middleware writers
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/:profile", app.requirePermission("debug:read", app.paramSwitch("profile", map[string]http.HandlerFunc{
+		"cmdline": pprof.Cmdline,
+		"profile": pprof.Profile,
+		"symbol":  pprof.Symbol,
+		"trace":   pprof.Trace,
+	}, app.pprofProfileHandler)))
+	router.HandlerFunc(http.MethodPost, "/debug/pprof/symbol", app.requirePermission("debug:read", pprof.Symbol))
+	// the metrics include the database pool stats, scrapers authenticate with a token of a user holding debug:read
+	router.HandlerFunc(http.MethodGet, "/metrics", app.requirePermission("debug:read", promhttp.HandlerFor(app.prometheus, promhttp.HandlerOpts{}).ServeHTTP))
 
-	return app.metrics(app.recoverPanic(app.enableCors(app.rateLimit(app.authenticate(router)))))
+	return app.requestID(app.securityHeaders(app.resolveClientIP(app.trace(router, app.accessLog(router, app.metrics(router, app.compress(app.negotiate(app.recoverPanic(app.filterIP(app.enableCors(app.authenticate(app.rateLimit(router)))))))))))))
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// referrerPolicies: the values of Referrer-Policy defined by the W3C Referrer Policy spec
+var referrerPolicies = []string{
+	"no-referrer",
+	"no-referrer-when-downgrade",
+	"origin",
+	"origin-when-cross-origin",
+	"same-origin",
+	"strict-origin",
+	"strict-origin-when-cross-origin",
+	"unsafe-url",
+}
+
+// securityHeaders: sets the hardening headers on every response, routes needing something else override them with withHeaders.
+// Browsers ignore Strict-Transport-Security on plain HTTP, so it is safe to send behind a TLS terminating proxy as well
+func (app *application) securityHeaders(next http.Handler) http.Handler {
+	hsts := ""
+	if app.config.security.hstsMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(app.config.security.hstsMaxAge.Seconds()))
+
+		if app.config.security.hstsIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if app.config.security.hstsPreload {
+			hsts += "; preload"
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := w.Header()
+
+		if hsts != "" {
+			headers.Set("Strict-Transport-Security", hsts)
+		}
+
+		headers.Set("X-Content-Type-Options", "nosniff")
+		headers.Set("Referrer-Policy", app.config.security.referrerPolicy)
+
+		if app.config.security.csp != "" {
+			headers.Set("Content-Security-Policy", app.config.security.csp)
+		}
+
+		// responses to authenticated requests hold the data of a user, neither the browser nor a shared cache may keep them
+		if r.Header.Get("Authorization") != "" {
+			headers.Set("Cache-Control", "no-store")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withHeaders: per route overrides of the headers set by securityHeaders, an empty value removes the header
+func (app *application) withHeaders(overrides map[string]string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range overrides {
+			if value == "" {
+				w.Header().Del(name)
+				continue
+			}
+			w.Header().Set(name, value)
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
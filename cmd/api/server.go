@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
+
+	"github.com/k1nho/letsgo/internal/certs"
+	"github.com/k1nho/letsgo/internal/jsonlog"
+	"github.com/k1nho/letsgo/internal/tracing"
 )
 
 func (app *application) serve() error {
@@ -22,8 +29,57 @@ func (app *application) serve() error {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	var redirect *http.Server
+
+	if app.config.tlsEnabled() {
+		reloader, err := certs.NewReloader(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = certs.ServerConfig(reloader)
+
+		// internal callers authenticate with a client certificate, public clients are only rejected when it is required
+		if app.config.tls.clientCAFile != "" {
+			srv.TLSConfig.ClientCAs, err = certs.LoadCertPool(app.config.tls.clientCAFile)
+			if err != nil {
+				return err
+			}
+
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			if app.config.tls.requireClientCert {
+				srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go reloader.Watch(ctx, app.config.tls.reloadInterval, func(err error) {
+			if err != nil {
+				app.logger.Error(err, jsonlog.Properties{"action": "certificate reload"})
+				return
+			}
+			app.logger.Info("certificate reloaded", jsonlog.Properties{"cert": app.config.tls.certFile})
+		})
+
+		if app.config.tls.redirectPort != 0 {
+			redirect = &http.Server{
+				Addr:         fmt.Sprintf(":%d", app.config.tls.redirectPort),
+				Handler:      app.redirectToHTTPS(),
+				ErrorLog:     log.New(app.logger, "", 0),
+				IdleTimeout:  time.Minute,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 10 * time.Second,
+			}
+		}
+	}
+
 	shutdownError := make(chan error)
 
+	go app.reloadOnSignal()
+	go app.refreshIPRules()
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 
@@ -32,6 +88,10 @@ func (app *application) serve() error {
 		// blocking until a signal is found
 		s := <-quit
 
+		// load balancers polling the readiness probe need some time to notice before the listener is closed
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.healthcheck.shutdownDelay)
+
 		app.logger.PrinfInfo("shutting down server", map[string]string{
 			"signal": s.String(),
 		})
@@ -39,9 +99,16 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err := srv.Shutdown(ctx)
+		// both listeners are always closed, main is blocked in srv's ListenAndServe until srv is shut down
+		var redirectErr error
+		if redirect != nil {
+			redirectErr = redirect.Shutdown(ctx)
+		}
+
+		err := errors.Join(redirectErr, srv.Shutdown(ctx))
 		if err != nil {
 			shutdownError <- err
+			return
 		}
 
 		app.logger.PrinfInfo("completing background tasks", map[string]string{
@@ -49,15 +116,40 @@ func (app *application) serve() error {
 		})
 
 		app.wg.Wait()
-		shutdownError <- nil
+
+		// the spans of the last requests and background tasks are still buffered by the exporter
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		shutdownError <- tracing.Shutdown(ctx)
 	}()
 
+	if redirect != nil {
+		go func() {
+			app.logger.PrinfInfo("starting HTTPS redirect server", map[string]string{
+				"addr": redirect.Addr,
+			})
+
+			err := redirect.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrinfError(err, map[string]string{"addr": redirect.Addr})
+			}
+		}()
+	}
+
 	app.logger.PrinfInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  strconv.FormatBool(app.config.tlsEnabled()),
 	})
 
-	err := srv.ListenAndServe()
+	var err error
+	if app.config.tlsEnabled() {
+		// the certificate comes from TLSConfig.GetCertificate, so no files are given here
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -74,3 +166,21 @@ func (app *application) serve() error {
 	return nil
 
 }
+
+// redirectToHTTPS: handler of the plain HTTP listener, sends every request to the same URL on the HTTPS port.
+// 308 is used instead of 301 so clients repeat non GET requests with the same method and body
+func (app *application) redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if app.config.port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+		}
+
+		w.Header().Set("Connection", "close")
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
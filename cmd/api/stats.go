@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k1nho/letsgo/internal/data"
+)
+
+// statsCache: keeps computed movie statistics per filter set for a short time, the aggregates scan the whole table
+type statsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]statsCacheEntry
+}
+
+type statsCacheEntry struct {
+	stats   *data.MovieStats
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{
+		ttl:     ttl,
+		entries: make(map[string]statsCacheEntry),
+	}
+}
+
+func (c *statsCache) get(key string) (*data.MovieStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[key]
+	if !found || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.stats, true
+}
+
+func (c *statsCache) set(key string, stats *data.MovieStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	// expired entries are dropped here, so the map never grows past the filters seen within one ttl
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = statsCacheEntry{stats: stats, expires: now.Add(c.ttl)}
+}
+
+// movieStatsHandler: Returns aggregated statistics of the movies matching the title and genres query params (JSON)
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	title := app.readString(qs, "title", "")
+	genres := app.readCSV(qs, "genres", []string{})
+
+	// genre order doesn't change the result, so it shouldn't change the cache key either
+	sortedGenres := append([]string(nil), genres...)
+	sort.Strings(sortedGenres)
+	key := title + "\x00" + strings.Join(sortedGenres, ",")
+
+	stats, found := app.statsCache.get(key)
+	if !found {
+		var err error
+
+		stats, err = app.models.Movies.GetStats(r.Context(), title, genres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.statsCache.set(key, stats)
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
+	"github.com/k1nho/letsgo/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// trace: starts the server span of the request, continuing the trace of an incoming W3C traceparent header.
+// Every span started further down the chain (middleware, queries, mails) becomes a child of it
+func (app *application) trace(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := app.routePattern(router, r)
+
+		name := r.Method
+		if route != "" {
+			name += " " + route
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("http.route", route),
+			attribute.String("user_agent.original", r.UserAgent()),
+			attribute.String("request_id", app.contextGetRequestID(r)),
+			attribute.String("client.address", app.contextGetClientIP(r)),
+		))
+		defer span.End()
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", metrics.Code),
+			attribute.Int64("http.response.body.size", metrics.Written),
+		)
+
+		// client errors are the caller's fault, only server errors mark the span as failed
+		if metrics.Code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(metrics.Code))
+		}
+	})
+}
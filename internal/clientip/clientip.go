@@ -0,0 +1,161 @@
+package clientip
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/k1nho/letsgo/internal/iprules"
+)
+
+var ErrInvalidProxy = errors.New("must be an IP address or a CIDR range")
+
+// Resolver: finds the address of the client behind the trusted proxies. Forwarding headers can be written by anyone,
+// so they are only read when the peer is a trusted proxy, and only the hops appended by trusted proxies are believed
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver: proxies are IP addresses or CIDR ranges, with none the peer address is always the client
+func NewResolver(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+
+	for _, proxy := range proxies {
+		prefix, err := ParseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, prefix)
+	}
+
+	return r, nil
+}
+
+// ParseProxy: parses a trusted proxy, a single address is a range holding only itself
+func ParseProxy(proxy string) (netip.Prefix, error) {
+	prefix, err := iprules.ParsePrefix(proxy)
+	if err != nil {
+		return netip.Prefix{}, ErrInvalidProxy
+	}
+	return prefix, nil
+}
+
+func (r *Resolver) trusts(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve: walks the forwarding chain from the peer towards the client, each trusted hop vouches for the address
+// before it. The first address not belonging to a trusted proxy is the client. The Forwarded header (RFC 7239) is
+// preferred over X-Forwarded-For and X-Real-IP. When a hop can't be read (e.g "unknown" or an obfuscated identifier)
+// the last address known is returned, which is a trusted proxy, rather than trusting anything further
+func (r *Resolver) Resolve(req *http.Request) string {
+	peer, ok := parseNode(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr
+	}
+
+	if !r.trusts(peer) {
+		return peer.String()
+	}
+
+	hops := forwardedFor(req.Header)
+	if hops == nil {
+		hops = forwardedList(req.Header.Values("X-Forwarded-For"))
+	}
+	if hops == nil {
+		hops = forwardedList(req.Header.Values("X-Real-Ip"))
+	}
+
+	client := peer
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseNode(hops[i])
+		if !ok {
+			break
+		}
+
+		client = addr
+		if !r.trusts(addr) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// forwardedFor: the for= parameter of every element of the Forwarded headers, in order. Elements without one can't
+// be attributed to a hop, so they end up as empty entries that stop the walk
+func forwardedFor(header http.Header) []string {
+	var hops []string
+
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range splitQuoted(value, ',') {
+			hop := ""
+
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hop = strings.Trim(strings.TrimSpace(value), `"`)
+				}
+			}
+
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+func forwardedList(values []string) []string {
+	var hops []string
+
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	return hops
+}
+
+// splitQuoted: splits on sep outside of quoted strings, quoted IPv6 nodes ("[2001:db8::1]:4711") contain no separator
+// but quoted values of other parameters may
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	quoted := false
+	start := 0
+
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// parseNode: an address with an optional port, IPv6 addresses with a port are bracketed ([2001:db8::1]:4711)
+func parseNode(node string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,153 @@
+package clientip
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "untrusted peer",
+			remote: "203.0.113.7:4711",
+			want:   "203.0.113.7",
+		},
+		{
+			name:    "untrusted peer forwarding headers are ignored",
+			remote:  "203.0.113.7:4711",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:   "trusted peer without forwarding headers",
+			remote: "10.0.0.1:4711",
+			want:   "10.0.0.1",
+		},
+		{
+			name:    "trusted peer",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "trusted hops are skipped",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.3, 10.0.0.2"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "addresses before the first untrusted hop are spoofable",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Forwarded-For": "192.0.2.1, 198.51.100.1, 10.0.0.2"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "every hop trusted",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "unreadable hop",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Forwarded-For": "192.0.2.1, unknown, 10.0.0.2"},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "X-Real-IP",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Real-Ip": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:   "Forwarded is preferred",
+			remote: "10.0.0.1:4711",
+			headers: map[string]string{
+				"Forwarded":       `for=198.51.100.1;proto=https, for="[2001:db8::1]:4711"`,
+				"X-Forwarded-For": "192.0.2.1",
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:    "Forwarded element without for",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"Forwarded": "for=198.51.100.1, proto=https"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "Forwarded quoted values",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"Forwarded": `for=198.51.100.1;host="a,b;c", for=10.0.0.2`},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "IPv4-mapped IPv6 peer",
+			remote:  "[::ffff:10.0.0.1]:4711",
+			headers: map[string]string{"X-Forwarded-For": "::ffff:198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "IPv6 trusted peer",
+			remote:  "[2001:db8::1]:4711",
+			headers: map[string]string{"X-Forwarded-For": "2001:db8::2"},
+			want:    "2001:db8::2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.RemoteAddr = tt.remote
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			got := resolver.Resolve(req)
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutProxies(t *testing.T) {
+	resolver, err := NewResolver(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.RemoteAddr = "10.0.0.1:4711"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	got := resolver.Resolve(req)
+	if got != "10.0.0.1" {
+		t.Errorf("got %s, want 10.0.0.1", got)
+	}
+}
+
+func TestNewResolverInvalidProxy(t *testing.T) {
+	_, err := NewResolver([]string{"10.0.0.0/8", "proxy.internal"})
+	if !errors.Is(err, ErrInvalidProxy) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidProxy)
+	}
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Genre struct {
+	Name       string `json:"name"`
+	MovieCount int    `json:"movie_count"`
+}
+
+type GenreModel struct {
+	DB DBTX
+}
+
+// WithTx: returns a copy of the model that runs its queries inside the given transaction
+func (m GenreModel) WithTx(tx *sql.Tx) GenreModel {
+	return GenreModel{DB: tracedDB{db: tx}}
+}
+
+// GetAllNames: returns the names in the controlled genre vocabulary
+func (m GenreModel) GetAllNames(ctx context.Context) ([]string, error) {
+	query := `
+        SELECT name
+        FROM genres
+        ORDER BY name ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// GetAll: returns every genre with the number of movies tagged with it.
+// With vocabulary set the genres come from the genres table (including unused ones), otherwise they are the ones found in movies
+func (m GenreModel) GetAll(ctx context.Context, vocabulary bool) ([]*Genre, error) {
+	query := `
+        SELECT genre, COUNT(*)
+        FROM movies, unnest(genres) AS genre
+        GROUP BY genre
+        ORDER BY genre ASC`
+
+	if vocabulary {
+		query = `
+        SELECT genres.name, COUNT(movies.id)
+        FROM genres
+        LEFT JOIN movies ON genres.name = ANY(movies.genres)
+        GROUP BY genres.name
+        ORDER BY genres.name ASC`
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.Name, &genre.MovieCount)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
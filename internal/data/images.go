@@ -0,0 +1,107 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/k1nho/letsgo/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	ImageKindPoster = "poster"
+	ImageKindStill  = "still"
+)
+
+type MovieImage struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	MovieID      int64     `json:"-"`
+	Kind         string    `json:"kind"`
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Size         int64     `json:"size"`
+	BlobKey      string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+/* Validator Contraints
+   -- Kind: must be poster or still
+   -- Width, Height: must be between 100 and 8000 pixels
+*/
+
+func ValidateMovieImage(v *validator.Validator, img *MovieImage) {
+	v.Check(validator.In(img.Kind, ImageKindPoster, ImageKindStill), "kind", "must be poster or still")
+
+	v.Check(img.Width >= 100 && img.Height >= 100, "image", "must be at least 100x100 pixels")
+	v.Check(img.Width <= 8000 && img.Height <= 8000, "image", "must not be larger than 8000x8000 pixels")
+}
+
+type MovieImageModel struct {
+	DB DBTX
+}
+
+// WithTx: the model running its queries in the transaction
+func (m MovieImageModel) WithTx(tx *sql.Tx) MovieImageModel {
+	return MovieImageModel{DB: tracedDB{db: tx}}
+}
+
+func (m MovieImageModel) Insert(ctx context.Context, img *MovieImage) error {
+	query := `
+        INSERT INTO movie_images(movie_id, kind, content_type, width, height, size, blob_key, thumbnail_key, url, thumbnail_url)
+        VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id, created_at
+    `
+
+	args := []interface{}{img.MovieID, img.Kind, img.ContentType, img.Width, img.Height, img.Size, img.BlobKey, img.ThumbnailKey, img.URL, img.ThumbnailURL}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&img.ID, &img.CreatedAt)
+}
+
+// GetAllForMovies: returns the images of every given movie, keyed by movie id
+func (m MovieImageModel) GetAllForMovies(ctx context.Context, movieIDs ...int64) (map[int64][]*MovieImage, error) {
+	images := make(map[int64][]*MovieImage)
+
+	if len(movieIDs) == 0 {
+		return images, nil
+	}
+
+	query := `
+        SELECT id, created_at, movie_id, kind, content_type, width, height, size, blob_key, thumbnail_key, url, thumbnail_url
+        FROM movie_images
+        WHERE movie_id = ANY($1)
+        ORDER BY id ASC
+    `
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var img MovieImage
+		err := rows.Scan(&img.ID, &img.CreatedAt, &img.MovieID, &img.Kind, &img.ContentType, &img.Width, &img.Height, &img.Size, &img.BlobKey, &img.ThumbnailKey, &img.URL, &img.ThumbnailURL)
+		if err != nil {
+			return nil, err
+		}
+		images[img.MovieID] = append(images[img.MovieID], &img)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
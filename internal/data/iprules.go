@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/k1nho/letsgo/internal/iprules"
+	"github.com/k1nho/letsgo/internal/validator"
+)
+
+var ErrDuplicateIPRule = errors.New("duplicate ip rule")
+
+type IPRule struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CIDR      string    `json:"cidr"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note"`
+}
+
+/* Validator Contraints
+   -- CIDR: must be an IP address or a CIDR range
+   -- Action: must be allow or deny
+   -- Note: must not be more than 500 bytes long
+*/
+
+func ValidateIPRule(v *validator.Validator, rule *IPRule) {
+	_, err := iprules.ParsePrefix(rule.CIDR)
+	v.Check(err == nil, "cidr", "must be an IP address or a CIDR range")
+
+	v.Check(validator.In(rule.Action, iprules.ActionAllow, iprules.ActionDeny), "action", "must be allow or deny")
+
+	v.Check(len(rule.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+type IPRuleModel struct {
+	DB DBTX
+}
+
+func (m IPRuleModel) Insert(ctx context.Context, rule *IPRule) error {
+	query := `
+        INSERT INTO ip_rules(cidr, action, note)
+        VALUES($1, $2, $3)
+        RETURNING id, created_at, cidr`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, rule.CIDR, rule.Action, rule.Note).Scan(&rule.ID, &rule.CreatedAt, &rule.CIDR)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "ip_rules_cidr_key"`:
+			return ErrDuplicateIPRule
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAll: returns every rule, most specific ranges first
+func (m IPRuleModel) GetAll(ctx context.Context) ([]*IPRule, error) {
+	query := `
+        SELECT id, created_at, cidr, action, note
+        FROM ip_rules
+        ORDER BY masklen(cidr) DESC, cidr ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rules := []*IPRule{}
+
+	for rows.Next() {
+		var rule IPRule
+
+		err := rows.Scan(&rule.ID, &rule.CreatedAt, &rule.CIDR, &rule.Action, &rule.Note)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (m IPRuleModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM ip_rules
+        WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	nRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if nRows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
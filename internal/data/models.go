@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -10,18 +11,40 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DBTX: the subset of methods shared by *sql.DB and *sql.Tx, so a model can run its queries inside a transaction
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
+	db          *sql.DB
 	Movies      MovieModel
+	MovieImages MovieImageModel
+	Genres      GenreModel
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionModel
+	IPRules     IPRuleModel
 }
 
 func NewModels(db *sql.DB) Models {
+	traced := tracedDB{db: db}
+
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		db:          db,
+		Movies:      MovieModel{DB: traced},
+		MovieImages: MovieImageModel{DB: traced},
+		Genres:      GenreModel{DB: traced},
+		Users:       UserModel{DB: traced},
+		Tokens:      TokenModel{DB: traced},
+		Permissions: PermissionModel{DB: traced},
+		IPRules:     IPRuleModel{DB: traced},
 	}
 }
+
+// BeginTx: starts a transaction, models bound to it with WithTx run their queries inside of it
+func (m Models) BeginTx(ctx context.Context) (*sql.Tx, error) {
+	return m.db.BeginTx(ctx, nil)
+}
@@ -12,55 +12,89 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres"`
-	Version   int32     `json:"version"`
+	ID        int64         `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Title     string        `json:"title"`
+	Year      int32         `json:"year"`
+	Runtime   Runtime       `json:"runtime,omitempty"`
+	Genres    []string      `json:"genres"`
+	Images    []*MovieImage `json:"images,omitempty"`
+	Version   int32         `json:"version"`
+}
+
+// MovieRules: the configurable limits movies are validated against
+type MovieRules struct {
+	MaxTitleBytes int
+	MinYear       int32
+	MaxGenres     int
+	// Genres: the controlled genre vocabulary, when nil any genre is accepted
+	Genres []string
+}
+
+// DefaultMovieRules: the limits used when no configuration is given
+func DefaultMovieRules() MovieRules {
+	return MovieRules{
+		MaxTitleBytes: 500,
+		MinYear:       1888,
+		MaxGenres:     5,
+	}
 }
 
 /* Validator Contraints
-   -- Title: must not be empty and must be less than or equal to 500 bytes long
-   -- Year: must not be 0, and must be greater than or equal to 1888 and cannot be in the future
+   -- Title: must not be empty and must be less than or equal to rules.MaxTitleBytes bytes long
+   -- Year: must not be 0, and must be greater than or equal to rules.MinYear and cannot be in the future (this year is allowed)
    -- Runtime: must not be 0 and has to be greater than 0
-   -- Genres: must not be nil and has to contain at least 1 and at most 5, also all the genres provided must be unique
+   -- Genres: must not be nil and has to contain at least 1 and at most rules.MaxGenres, also all the genres provided must be unique
+      and part of rules.Genres when a vocabulary is configured
 */
 
-func ValidateMovie(v *validator.Validator, m *Movie) {
+func ValidateMovie(v *validator.Validator, m *Movie, rules MovieRules) {
 	v.Check(m.Title != "", "title", "must be provided")
-	v.Check(len(m.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(m.Title) <= rules.MaxTitleBytes, "title", fmt.Sprintf("must not be more than %d bytes long", rules.MaxTitleBytes))
 
 	v.Check(m.Year != 0, "year", "must be provided")
-	v.Check(m.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(m.Year < int32(time.Now().Year()), "year", "must not be in the future")
+	v.Check(m.Year >= rules.MinYear, "year", fmt.Sprintf("must be greater than or equal to %d", rules.MinYear))
+	v.Check(m.Year <= int32(time.Now().Year()), "year", "must not be in the future")
 
 	v.Check(m.Runtime != 0, "runtime", "must be provided")
 	v.Check(m.Runtime > 0, "runtime", "must be positive")
 
 	v.Check(m.Genres != nil, "genres", "must be provided")
 	v.Check(len(m.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(m.Genres) <= 5, "genres", "must not exceeed 5 genres")
+	v.Check(len(m.Genres) <= rules.MaxGenres, "genres", fmt.Sprintf("must not exceed %d genres", rules.MaxGenres))
 	v.Check(validator.Unique(m.Genres), "genres", "must not contain duplicates")
 
+	if rules.Genres != nil {
+		for _, genre := range m.Genres {
+			v.Check(validator.In(genre, rules.Genres...), "genres", fmt.Sprintf("contains unknown genre %q", genre))
+		}
+	}
+
 }
 
+// movieFilterClause: filters movies by full text search on the title ($1) and by containing all the genres ($2), empty values match everything
+const movieFilterClause = `(to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1='')
+        AND (genres @> $2 OR $2 = '{}')`
+
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
+}
+
+// WithTx: returns a copy of the model that runs its queries inside the given transaction
+func (m MovieModel) WithTx(tx *sql.Tx) MovieModel {
+	return MovieModel{DB: tracedDB{db: tx}}
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
 
 	query := fmt.Sprintf(`
         SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version 
         FROM movies
-        WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1='')
-        AND (genres @> $2 OR $2 = '{}')
+        WHERE %s
         ORDER BY %s %s, id ASC
-        LIMIT $3 OFFSET $4`, filters.SortColumn(), filters.sortDirection())
+        LIMIT $3 OFFSET $4`, movieFilterClause, filters.SortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres), filters.limit(), filters.offset())
@@ -86,6 +120,11 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
+	err = m.attachImages(ctx, movies...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
 	return movies, metadata, nil
@@ -93,7 +132,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 }
 
 // Get returns a movie given an id
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 
 	if id < 1 {
 		return nil, ErrRecordNotFound
@@ -107,7 +146,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	var movie Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version)
@@ -119,11 +158,36 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 			return nil, err
 		}
 	}
+
+	err = m.attachImages(ctx, &movie)
+	if err != nil {
+		return nil, err
+	}
+
 	return &movie, nil
 }
 
+// attachImages: loads the images of the given movies with a single query
+func (m MovieModel) attachImages(ctx context.Context, movies ...*Movie) error {
+	ids := make([]int64, len(movies))
+	for i := range movies {
+		ids[i] = movies[i].ID
+	}
+
+	images, err := MovieImageModel{DB: m.DB}.GetAllForMovies(ctx, ids...)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.Images = images[movie.ID]
+	}
+
+	return nil
+}
+
 // Insert: insert a Movie given title, year, runtime, genres
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	query := `
         INSERT INTO movies(title, year, runtime, genres)
         VALUES($1, $2, $3, $4)
@@ -132,14 +196,14 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
 // Update: updates a Movie, given title, year, runtime, genres
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 
 	query := `
         UPDATE movies
@@ -150,7 +214,7 @@ func (m MovieModel) Update(movie *Movie) error {
 
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
@@ -167,7 +231,7 @@ func (m MovieModel) Update(movie *Movie) error {
 }
 
 // Delete: deletes a Movie given an id
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -177,7 +241,7 @@ func (m MovieModel) Delete(id int64) error {
         WHERE id=$1
     `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type RuntimeStats struct {
+	Total   int64   `json:"total_mins"`
+	Average float64 `json:"average_mins"`
+	Min     int32   `json:"min_mins"`
+	Max     int32   `json:"max_mins"`
+	P50     float64 `json:"p50_mins"`
+	P90     float64 `json:"p90_mins"`
+	P99     float64 `json:"p99_mins"`
+}
+
+type GenreCount struct {
+	Genre string `json:"genre"`
+	Count int    `json:"count"`
+}
+
+type YearCount struct {
+	Year  int32 `json:"year"`
+	Count int   `json:"count"`
+}
+
+type DecadeCount struct {
+	Decade int32 `json:"decade"`
+	Count  int   `json:"count"`
+}
+
+type GenreDecadeCount struct {
+	Genre  string `json:"genre"`
+	Decade int32  `json:"decade"`
+	Count  int    `json:"count"`
+}
+
+type MovieStats struct {
+	TotalMovies   int                `json:"total_movies"`
+	Runtime       RuntimeStats       `json:"runtime"`
+	ByGenre       []GenreCount       `json:"by_genre"`
+	ByYear        []YearCount        `json:"by_year"`
+	ByDecade      []DecadeCount      `json:"by_decade"`
+	ByGenreDecade []GenreDecadeCount `json:"by_genre_decade"`
+}
+
+// GetStats: aggregates the movies matching the same title and genres filters as GetAll
+func (m MovieModel) GetStats(ctx context.Context, title string, genres []string) (*MovieStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{title, pq.Array(genres)}
+
+	stats := MovieStats{
+		ByGenre:       []GenreCount{},
+		ByYear:        []YearCount{},
+		ByDecade:      []DecadeCount{},
+		ByGenreDecade: []GenreDecadeCount{},
+	}
+
+	query := fmt.Sprintf(`
+        SELECT COUNT(*), COALESCE(SUM(runtime), 0), COALESCE(AVG(runtime), 0), COALESCE(MIN(runtime), 0), COALESCE(MAX(runtime), 0),
+            percentile_cont(ARRAY[0.5, 0.9, 0.99]) WITHIN GROUP (ORDER BY runtime)
+        FROM movies
+        WHERE %s`, movieFilterClause)
+
+	var percentiles pq.Float64Array
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&stats.TotalMovies, &stats.Runtime.Total, &stats.Runtime.Average,
+		&stats.Runtime.Min, &stats.Runtime.Max, &percentiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(percentiles) == 3 {
+		stats.Runtime.P50, stats.Runtime.P90, stats.Runtime.P99 = percentiles[0], percentiles[1], percentiles[2]
+	}
+
+	query = fmt.Sprintf(`
+        SELECT genre, COUNT(*)
+        FROM movies, unnest(genres) AS genre
+        WHERE %s
+        GROUP BY genre
+        ORDER BY COUNT(*) DESC, genre ASC`, movieFilterClause)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c GenreCount
+		if err := rows.Scan(&c.Genre, &c.Count); err != nil {
+			return nil, err
+		}
+		stats.ByGenre = append(stats.ByGenre, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = fmt.Sprintf(`
+        SELECT year, COUNT(*)
+        FROM movies
+        WHERE %s
+        GROUP BY year
+        ORDER BY year ASC`, movieFilterClause)
+
+	rows, err = m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c YearCount
+		if err := rows.Scan(&c.Year, &c.Count); err != nil {
+			return nil, err
+		}
+		stats.ByYear = append(stats.ByYear, c)
+
+		// years come ordered, so a decade is complete as soon as the next one starts
+		decade := c.Year / 10 * 10
+		if n := len(stats.ByDecade); n > 0 && stats.ByDecade[n-1].Decade == decade {
+			stats.ByDecade[n-1].Count += c.Count
+		} else {
+			stats.ByDecade = append(stats.ByDecade, DecadeCount{Decade: decade, Count: c.Count})
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = fmt.Sprintf(`
+        SELECT genre, year / 10 * 10 AS decade, COUNT(*)
+        FROM movies, unnest(genres) AS genre
+        WHERE %s
+        GROUP BY genre, decade
+        ORDER BY genre ASC, decade ASC`, movieFilterClause)
+
+	rows, err = m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c GenreDecadeCount
+		if err := rows.Scan(&c.Genre, &c.Decade, &c.Count); err != nil {
+			return nil, err
+		}
+		stats.ByGenreDecade = append(stats.ByGenreDecade, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base32"
 	"time"
 
@@ -48,7 +47,7 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func ValidateTokenPlainText(v *validator.Validator, plaintext string) {
@@ -56,13 +55,13 @@ func ValidateTokenPlainText(v *validator.Validator, plaintext string) {
 	v.Check(len(plaintext) == 26, "token", "must be 26 bytes long")
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +69,7 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, nil
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
         INSERT INTO tokens(hash, user_id, expiry, scope)
         VALUES($1, $2, $3, $4)
@@ -78,20 +77,20 @@ func (m TokenModel) Insert(token *Token) error {
 
 	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
-func (m TokenModel) DeleteAllTokens(userId int64, scope string) error {
+func (m TokenModel) DeleteAllTokens(ctx context.Context, userId int64, scope string) error {
 	query := `
        DELETE FROM tokens 
        WHERE user_id=$1 AND scope=$2
     `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userId, scope)
@@ -89,10 +89,10 @@ func (p *password) Matches(plaintextpassword string) (bool, error) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
        INSERT INTO users(name, email, password_hash, activated)
        VALUES($1, $2, $3, $4)
@@ -101,7 +101,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -116,7 +116,7 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetUserByEmail(email string) (*User, error) {
+func (m UserModel) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
         SELECT id, created_at, name, email, password_hash, activated, version
         FROM users
@@ -125,7 +125,7 @@ func (m UserModel) GetUserByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Password.hash, &user.Activated, &user.Version)
@@ -141,7 +141,7 @@ func (m UserModel) GetUserByEmail(email string) (*User, error) {
 
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 
 	query := `
         UPDATE users
@@ -151,7 +151,7 @@ func (m UserModel) Update(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -168,7 +168,7 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope string, tokenplaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope string, tokenplaintext string) (*User, error) {
 
 	tokenHash := sha256.Sum256([]byte(tokenplaintext))
 
@@ -183,7 +183,7 @@ func (m UserModel) GetForToken(tokenScope string, tokenplaintext string) (*User,
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Password.hash, &user.Activated, &user.Version)
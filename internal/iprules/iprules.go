@@ -0,0 +1,142 @@
+package iprules
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+var ErrInvalidPrefix = errors.New("must be an IP address or a CIDR range")
+
+// Rule: allows or denies the addresses of a range
+type Rule struct {
+	ID     int64
+	Prefix netip.Prefix
+	Action string
+}
+
+// ParsePrefix: parses an IP address or a CIDR range, a single address is a range holding only itself. Host bits are
+// cleared and IPv4-mapped IPv6 addresses are stored as IPv4, so equal ranges always have the same representation
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, ErrInvalidPrefix
+		}
+
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		return netip.Prefix{}, ErrInvalidPrefix
+	}
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Tree: a binary radix tree of rules keyed by the bits of their range, looking up an address walks at most 32 (IPv4)
+// or 128 (IPv6) nodes whatever the number of rules. It is not safe for concurrent writes, it is built once and then
+// only read, changes build a new tree
+type Tree struct {
+	v4  node
+	v6  node
+	len int
+}
+
+type node struct {
+	children [2]*node
+	rule     *Rule
+}
+
+// NewTree: builds the tree of the rules, when two rules have the same range the deny rule is kept
+func NewTree(rules []Rule) *Tree {
+	t := &Tree{}
+
+	for i := range rules {
+		t.insert(rules[i])
+	}
+
+	return t
+}
+
+func (t *Tree) insert(rule Rule) {
+	prefix := rule.Prefix.Masked()
+	addr := prefix.Addr()
+
+	n := &t.v6
+	if addr.Is4() {
+		n = &t.v4
+	}
+
+	bytes := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := bytes[i/8] >> (7 - i%8) & 1
+
+		if n.children[bit] == nil {
+			n.children[bit] = &node{}
+		}
+		n = n.children[bit]
+	}
+
+	if n.rule == nil {
+		t.len++
+	} else if n.rule.Action == ActionDeny {
+		return
+	}
+
+	n.rule = &rule
+}
+
+// Lookup: the rule of the most specific range holding the address, so an allowed range can be carved out of a denied
+// one (or the other way around)
+func (t *Tree) Lookup(addr netip.Addr) (Rule, bool) {
+	addr = addr.Unmap()
+
+	n := &t.v6
+	if addr.Is4() {
+		n = &t.v4
+	}
+
+	var match *Rule
+	bytes := addr.AsSlice()
+
+	for i := 0; n != nil; i++ {
+		if n.rule != nil {
+			match = n.rule
+		}
+
+		if i == len(bytes)*8 {
+			break
+		}
+
+		n = n.children[bytes[i/8]>>(7-i%8)&1]
+	}
+
+	if match == nil {
+		return Rule{}, false
+	}
+
+	return *match, true
+}
+
+// Allowed: addresses not covered by any rule are allowed, denying 0.0.0.0/0 and ::/0 turns the allow rules into an allowlist
+func (t *Tree) Allowed(addr netip.Addr) (Rule, bool) {
+	rule, ok := t.Lookup(addr)
+	return rule, !ok || rule.Action == ActionAllow
+}
+
+// Len: the number of ranges in the tree
+func (t *Tree) Len() int {
+	return t.len
+}
@@ -0,0 +1,139 @@
+package iprules
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "10.0.0.1", want: "10.0.0.1/32"},
+		{in: "10.0.0.1/24", want: "10.0.0.0/24"},
+		{in: "::ffff:10.0.0.1", want: "10.0.0.1/32"},
+		{in: "::ffff:10.0.0.0/104", want: "10.0.0.0/8"},
+		{in: "2001:db8::1/32", want: "2001:db8::/32"},
+		{in: "2001:db8::1", want: "2001:db8::1/128"},
+		{in: "fe80::1%eth0", err: true},
+		{in: "10.0.0.0/33", err: true},
+		{in: "example.com", err: true},
+		{in: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePrefix(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %s, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTreeLookup(t *testing.T) {
+	var rules []Rule
+	for i, rule := range []struct{ prefix, action string }{
+		{"10.0.0.0/8", ActionDeny},
+		{"10.1.0.0/16", ActionAllow},
+		{"10.1.2.0/24", ActionDeny},
+		{"10.1.2.3", ActionAllow},
+		{"192.168.0.0/16", ActionAllow},
+		{"192.168.0.0/16", ActionDeny},
+		{"2001:db8::/32", ActionDeny},
+		{"2001:db8:1::/48", ActionAllow},
+	} {
+		prefix, err := ParsePrefix(rule.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, Rule{ID: int64(i + 1), Prefix: prefix, Action: rule.action})
+	}
+
+	tree := NewTree(rules)
+
+	if tree.Len() != 7 {
+		t.Errorf("got %d ranges, want 7", tree.Len())
+	}
+
+	tests := []struct {
+		addr    string
+		rule    int64
+		allowed bool
+	}{
+		{addr: "10.200.0.1", rule: 1, allowed: false},
+		{addr: "10.1.200.1", rule: 2, allowed: true},
+		{addr: "10.1.2.4", rule: 3, allowed: false},
+		{addr: "10.1.2.3", rule: 4, allowed: true},
+		// the deny rule wins over the allow rule of the same range
+		{addr: "192.168.1.1", rule: 6, allowed: false},
+		{addr: "172.16.0.1", allowed: true},
+		// IPv4-mapped IPv6 addresses match the IPv4 rules
+		{addr: "::ffff:10.1.2.3", rule: 4, allowed: true},
+		{addr: "::ffff:10.1.2.4", rule: 3, allowed: false},
+		{addr: "2001:db8:2::1", rule: 7, allowed: false},
+		{addr: "2001:db8:1::1", rule: 8, allowed: true},
+		{addr: "2001:db9::1", allowed: true},
+		// IPv4 rules don't leak into the IPv6 tree
+		{addr: "a00::1", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			rule, allowed := tree.Allowed(netip.MustParseAddr(tt.addr))
+
+			if rule.ID != tt.rule {
+				t.Errorf("got rule %d, want %d", rule.ID, tt.rule)
+			}
+			if allowed != tt.allowed {
+				t.Errorf("got allowed %t, want %t", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestTreeDenyAll(t *testing.T) {
+	tree := NewTree([]Rule{
+		{ID: 1, Prefix: netip.MustParsePrefix("0.0.0.0/0"), Action: ActionDeny},
+		{ID: 2, Prefix: netip.MustParsePrefix("::/0"), Action: ActionDeny},
+		{ID: 3, Prefix: netip.MustParsePrefix("203.0.113.7/32"), Action: ActionAllow},
+	})
+
+	for addr, want := range map[string]bool{
+		"203.0.113.7": true,
+		"203.0.113.8": false,
+		"::1":         false,
+		"0.0.0.0":     false,
+	} {
+		_, allowed := tree.Allowed(netip.MustParseAddr(addr))
+		if allowed != want {
+			t.Errorf("%s: got allowed %t, want %t", addr, allowed, want)
+		}
+	}
+}
+
+func TestTreeEmpty(t *testing.T) {
+	tree := NewTree(nil)
+
+	_, ok := tree.Lookup(netip.MustParseAddr("10.0.0.1"))
+	if ok {
+		t.Error("empty tree matched an address")
+	}
+
+	_, allowed := tree.Allowed(netip.MustParseAddr("10.0.0.1"))
+	if !allowed {
+		t.Error("empty tree denied an address")
+	}
+}
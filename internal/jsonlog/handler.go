@@ -0,0 +1,215 @@
+package jsonlog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HandlerOptions: configures a Handler, the zero value logs INFO and above without stack traces
+type HandlerOptions struct {
+	Level  Level
+	Traces bool
+}
+
+// handlerCore: the state shared between a handler and the ones derived from it with WithAttrs and WithGroup
+type handlerCore struct {
+	out    io.Writer
+	mu     sync.Mutex
+	level  slog.LevelVar
+	traces atomic.Bool
+}
+
+// Handler: slog.Handler writing one JSON object per line with the Level, Time, Message, Properties and Trace keys
+type Handler struct {
+	core *handlerCore
+	// attrs: attributes bound with WithAttrs, already nested into their groups
+	attrs Properties
+	// groups: the open groups, record attributes are nested under them
+	groups []string
+}
+
+func NewHandler(out io.Writer, opts *HandlerOptions) *Handler {
+	if opts == nil {
+		opts = &HandlerOptions{Level: LevelInfo}
+	}
+
+	c := &handlerCore{out: out}
+	c.level.Set(opts.Level.slogLevel())
+	c.traces.Store(opts.Traces)
+
+	return &Handler{core: c}
+}
+
+// SetLevel: changes the minimum level of the handler and every handler derived from it
+func (h *Handler) SetLevel(level Level) {
+	h.core.level.Set(level.slogLevel())
+}
+
+func (h *Handler) Level() Level {
+	return levelFromSlog(h.core.level.Level())
+}
+
+// SetTraces: enables or disables capturing the stack trace on ERROR and FATAL entries
+func (h *Handler) SetTraces(enabled bool) {
+	h.core.traces.Store(enabled)
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.core.level.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, record slog.Record) error {
+	level := levelFromSlog(record.Level)
+
+	properties := cloneProperties(h.attrs)
+	target := openGroups(properties, h.groups)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(target, attr)
+		return true
+	})
+
+	t := record.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	aux := struct {
+		Level      string
+		Time       string
+		Message    string
+		Properties Properties
+		Trace      string
+	}{
+		Level:      level.String(),
+		Time:       t.UTC().Format(time.RFC3339),
+		Message:    record.Message,
+		Properties: properties,
+	}
+
+	if level >= LevelError && h.core.traces.Load() {
+		aux.Trace = string(debug.Stack())
+	}
+
+	var line []byte
+
+	line, err := json.Marshal(aux)
+	if err != nil {
+		line = []byte(LevelError.String() + ": unable to marshal log message" + err.Error())
+	}
+
+	h.core.mu.Lock()
+	defer h.core.mu.Unlock()
+
+	_, err = h.core.out.Write(append(line, '\n'))
+	return err
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	bound := cloneProperties(h.attrs)
+	target := openGroups(bound, h.groups)
+	for _, attr := range attrs {
+		addAttr(target, attr)
+	}
+
+	return &Handler{core: h.core, attrs: bound, groups: h.groups}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	groups := make([]string, len(h.groups), len(h.groups)+1)
+	copy(groups, h.groups)
+
+	return &Handler{core: h.core, attrs: h.attrs, groups: append(groups, name)}
+}
+
+// openGroups: returns the map the attributes of the innermost group go into, creating the nested maps on the way
+func openGroups(properties Properties, groups []string) Properties {
+	target := properties
+	for _, group := range groups {
+		nested, ok := target[group].(Properties)
+		if !ok {
+			nested = Properties{}
+			target[group] = nested
+		}
+		target = nested
+	}
+	return target
+}
+
+// cloneProperties: deep copies the nested groups so derived handlers never write into their parent's attributes
+func cloneProperties(properties Properties) Properties {
+	c := make(Properties, len(properties))
+	for key, val := range properties {
+		if nested, ok := val.(Properties); ok {
+			val = cloneProperties(nested)
+		}
+		c[key] = val
+	}
+	return c
+}
+
+func addAttr(target Properties, attr slog.Attr) {
+	val := attr.Value.Resolve()
+
+	if val.Kind() == slog.KindGroup {
+		group := val.Group()
+		if len(group) == 0 {
+			return
+		}
+
+		// attributes of a group without a key are inlined, as slog handlers are expected to
+		nested := target
+		if attr.Key != "" {
+			nested = openGroups(target, []string{attr.Key})
+		}
+		for _, a := range group {
+			addAttr(nested, a)
+		}
+		return
+	}
+
+	if attr.Key == "" {
+		return
+	}
+
+	target[attr.Key] = attrValue(val)
+}
+
+// attrValue: converts a resolved value into something that marshals into readable JSON
+func attrValue(val slog.Value) any {
+	switch val.Kind() {
+	case slog.KindDuration:
+		return val.Duration().String()
+	case slog.KindTime:
+		return val.Time().UTC().Format(time.RFC3339)
+	case slog.KindAny:
+		switch v := val.Any().(type) {
+		case error:
+			return v.Error()
+		case Properties:
+			converted := make(Properties, len(v))
+			for key, nested := range v {
+				converted[key] = attrValue(slog.AnyValue(nested))
+			}
+			return converted
+		default:
+			return v
+		}
+	default:
+		return val.Any()
+	}
+}
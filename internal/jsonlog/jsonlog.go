@@ -1,97 +1,239 @@
 package jsonlog
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"os"
-	"runtime/debug"
-	"sync"
-	"time"
+	"sort"
+	"strings"
 )
 
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
 )
 
+var ErrInvalidLevel = errors.New("invalid log level")
+
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
-type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+// ParseLevel: returns the level for its name, case insensitive
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidLevel, s)
 }
 
-func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
 	}
+	*l = level
+	return nil
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
-		return 0, nil
+// Properties: the typed fields of a log entry, values are marshalled as JSON except for errors and durations which are written as strings
+type Properties map[string]any
+
+// levelFatal: slog has no fatal level, it is placed one step above error
+const levelFatal = slog.LevelError + 4
+
+// slogLevel: converts the level into the slog one, LevelOff maps above every level that is ever logged
+func (l Level) slogLevel() slog.Level {
+	switch l {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	case LevelFatal:
+		return levelFatal
+	default:
+		return slog.Level(math.MaxInt32)
 	}
+}
 
-	aux := struct {
-		Level      string
-		Time       string
-		Message    string
-		Properties map[string]string
-		Trace      string
-	}{
-		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+// levelFromSlog: rounds a slog level down to the closest jsonlog level
+func levelFromSlog(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	case level < levelFatal:
+		return LevelError
+	case level < slog.Level(math.MaxInt32):
+		return LevelFatal
+	default:
+		return LevelOff
 	}
+}
+
+// Logger: thin wrapper over a *slog.Logger keeping the original jsonlog API, so the application and any library logging
+// through log/slog end up in the same stream
+type Logger struct {
+	sl *slog.Logger
+}
+
+// New: returns a logger writing JSON lines to out through a Handler
+func New(out io.Writer, minLevel Level) *Logger {
+	return FromSlog(slog.New(NewHandler(out, &HandlerOptions{Level: minLevel, Traces: true})))
+}
+
+// FromSlog: wraps any *slog.Logger, level changes only take effect when its handler is a jsonlog Handler
+func FromSlog(sl *slog.Logger) *Logger {
+	return &Logger{sl: sl}
+}
+
+// Slog: returns the underlying *slog.Logger
+func (l *Logger) Slog() *slog.Logger {
+	return l.sl
+}
+
+// With: returns a child logger that adds the given fields to every entry
+func (l *Logger) With(fields Properties) *Logger {
+	return &Logger{sl: slog.New(l.sl.Handler().WithAttrs(attrs(fields)))}
+}
 
-	if level >= LevelError {
-		aux.Trace = string(debug.Stack())
+// Level: returns the current minimum level
+func (l *Logger) Level() Level {
+	if h, ok := l.sl.Handler().(*Handler); ok {
+		return h.Level()
 	}
 
-	var line []byte
+	for level := LevelDebug; level < LevelOff; level++ {
+		if l.Enabled(level) {
+			return level
+		}
+	}
+	return LevelOff
+}
 
-	line, err := json.Marshal(aux)
-	if err != nil {
-		line = []byte(LevelError.String() + ": unable to marshal log message" + err.Error())
+// SetLevel: changes the minimum level at runtime, for this logger and every logger sharing its handler
+func (l *Logger) SetLevel(level Level) {
+	if h, ok := l.sl.Handler().(*Handler); ok {
+		h.SetLevel(level)
 	}
+}
+
+// SetTraces: enables or disables capturing the stack trace on ERROR and FATAL entries
+func (l *Logger) SetTraces(enabled bool) {
+	if h, ok := l.sl.Handler().(*Handler); ok {
+		h.SetTraces(enabled)
+	}
+}
+
+// Enabled: reports whether entries of the given level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l.sl.Enabled(context.Background(), level.slogLevel())
+}
+
+func (l *Logger) print(level Level, message string, properties Properties) {
+	l.sl.LogAttrs(context.Background(), level.slogLevel(), message, attrs(properties)...)
+}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// attrs: converts the properties into slog attributes, sorted so the output doesn't depend on map order
+func attrs(properties Properties) []slog.Attr {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	return l.out.Write(append(line, '\n'))
+	as := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		as = append(as, slog.Any(key, properties[key]))
+	}
+	return as
 }
 
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	l.print(LevelError, string(message), nil)
+	return len(message), nil
 }
 
-func (l *Logger) PrinfInfo(message string, properties map[string]string) {
+func (l *Logger) Debug(message string, properties Properties) {
+	l.print(LevelDebug, message, properties)
+}
+
+func (l *Logger) Info(message string, properties Properties) {
 	l.print(LevelInfo, message, properties)
 }
 
-func (l *Logger) PrinfError(err error, properties map[string]string) {
+func (l *Logger) Warn(message string, properties Properties) {
+	l.print(LevelWarn, message, properties)
+}
+
+func (l *Logger) Error(err error, properties Properties) {
 	l.print(LevelError, err.Error(), properties)
 }
 
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
+func (l *Logger) Fatal(err error, properties Properties) {
 	l.print(LevelFatal, err.Error(), properties)
 	os.Exit(1) // Fatal level message will also terminate the application
 }
+
+// stringProperties: converts the properties of the original string only API
+func stringProperties(properties map[string]string) Properties {
+	if properties == nil {
+		return nil
+	}
+
+	props := make(Properties, len(properties))
+	for key, val := range properties {
+		props[key] = val
+	}
+	return props
+}
+
+func (l *Logger) PrinfInfo(message string, properties map[string]string) {
+	l.Info(message, stringProperties(properties))
+}
+
+func (l *Logger) PrinfError(err error, properties map[string]string) {
+	l.Error(err, stringProperties(properties))
+}
+
+func (l *Logger) PrintFatal(err error, properties map[string]string) {
+	l.Fatal(err, stringProperties(properties))
+}
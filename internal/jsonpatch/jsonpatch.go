@@ -0,0 +1,412 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	ErrInvalidPatch  = errors.New("invalid patch document")
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrPathNotFound  = errors.New("path not found")
+	ErrTestFailed    = errors.New("test operation failed")
+	ErrInvalidTarget = errors.New("invalid target document")
+)
+
+// Operation: a single RFC 6902 operation
+type Operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from,omitempty"`
+	// Value: stays empty when the member is absent, a null value is kept as the literal null
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MergePatch: applies a RFC 7396 merge patch to the target document and returns the resulting document
+func MergePatch(target, patch []byte) ([]byte, error) {
+	doc, err := decode(target)
+	if err != nil {
+		return nil, ErrInvalidTarget
+	}
+
+	p, err := decode(patch)
+	if err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	return json.Marshal(mergeValue(doc, p))
+}
+
+// mergeValue: if the patch is an object its members are merged recursively into the target (null removes a member),
+// any other patch value replaces the target completely
+func mergeValue(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+
+	for key, val := range patchObj {
+		if val == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], val)
+	}
+
+	return targetObj
+}
+
+// Apply: applies a RFC 6902 JSON patch to the target document and returns the resulting document.
+// The operations are applied in order and the first failing operation aborts the whole patch
+func Apply(target, patch []byte) ([]byte, error) {
+	doc, err := decode(target)
+	if err != nil {
+		return nil, ErrInvalidTarget
+	}
+
+	var ops []Operation
+
+	// members not defined for an operation must be ignored (RFC 6902 section 4)
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	for i, op := range ops {
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+func applyOperation(doc any, op Operation) (any, error) {
+	switch op.Op {
+	case "add":
+		val, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, val)
+	case "remove":
+		doc, _, err := remove(doc, op.Path)
+		return doc, err
+	case "replace":
+		val, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		doc, _, err = remove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, val)
+	case "move":
+		if op.Path == op.From || strings.HasPrefix(op.Path, op.From+"/") {
+			if op.Path != op.From {
+				return nil, fmt.Errorf("%w: cannot move a value into one of its children", ErrInvalidPath)
+			}
+			return doc, nil
+		}
+		doc, val, err := remove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, val)
+	case "copy":
+		val, err := get(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, deepCopy(val))
+	case "test":
+		expected, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		val, err := get(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(val, expected) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// value: decodes the value member of an operation, it must be present for add, replace and test
+func (op Operation) value() (any, error) {
+	if len(op.Value) == 0 {
+		return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+	}
+	return decode(op.Value)
+}
+
+// parsePointer: splits a RFC 6901 JSON pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: %q must start with /", ErrInvalidPath, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex: parses a reference token as an array index, allowing n == len(array) when appending
+func arrayIndex(token string, length int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return length, nil
+	}
+
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPath, token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPath, token)
+	}
+
+	max := length - 1
+	if appending {
+		max = length
+	}
+
+	if i > max {
+		return 0, fmt.Errorf("%w: array index %d out of bounds", ErrPathNotFound, i)
+	}
+
+	return i, nil
+}
+
+func get(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]any:
+			val, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+			}
+			current = val
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+		}
+	}
+
+	return current, nil
+}
+
+// add: sets the value at pointer, creating object members and inserting into arrays, and returns the new document
+func add(doc any, pointer string, val any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return val, nil
+	}
+
+	return update(doc, tokens, pointer, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = val
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = val
+			return node, nil
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+		}
+	})
+}
+
+// remove: deletes the value at pointer and returns the new document along with the removed value
+func remove(doc any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+
+	var removed any
+
+	doc, err = update(doc, tokens, pointer, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			val, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+			}
+			removed = val
+			delete(node, token)
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+		}
+	})
+
+	return doc, removed, err
+}
+
+// update: walks down to the parent of the last token, calls fn on it and stores the (possibly reallocated) parent back
+func update(doc any, tokens []string, pointer string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+		}
+		child, err := update(child, tokens[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+		node[tokens[0]] = child
+		return node, nil
+	case []any:
+		i, err := arrayIndex(tokens[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := update(node[i], tokens[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+	}
+}
+
+// decode: decodes a JSON document keeping numbers as json.Number so no precision is lost on the round trip
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func deepCopy(v any) any {
+	switch node := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(node))
+		for key, val := range node {
+			c[key] = deepCopy(val)
+		}
+		return c
+	case []any:
+		c := make([]any, len(node))
+		for i, val := range node {
+			c[i] = deepCopy(val)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// equal: compares two decoded documents, numbers are compared by value so 1 and 1.0 are equal
+func equal(a, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, val := range x {
+			other, ok := y[key]
+			if !ok || !equal(val, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		if errX == nil && errY == nil {
+			return fx == fy
+		}
+		return x == y
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,218 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+)
+
+// the examples of RFC 6902 Appendix A, plus the cases the appendix leaves out
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+		err    error
+	}{
+		{
+			name:   "A.1 adding an object member",
+			target: `{"foo": "bar"}`,
+			patch:  `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:   `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name:   "A.2 adding an array element",
+			target: `{"foo": ["bar", "baz"]}`,
+			patch:  `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:   `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:   "A.3 removing an object member",
+			target: `{"baz": "qux", "foo": "bar"}`,
+			patch:  `[{"op": "remove", "path": "/baz"}]`,
+			want:   `{"foo": "bar"}`,
+		},
+		{
+			name:   "A.4 removing an array element",
+			target: `{"foo": ["bar", "qux", "baz"]}`,
+			patch:  `[{"op": "remove", "path": "/foo/1"}]`,
+			want:   `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:   "A.5 replacing a value",
+			target: `{"baz": "qux", "foo": "bar"}`,
+			patch:  `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:   `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:   "A.6 moving a value",
+			target: `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch:  `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:   `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name:   "A.7 moving an array element",
+			target: `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch:  `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want:   `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name:   "A.8 testing a value: success",
+			target: `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch:  `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			want:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:   "A.9 testing a value: error",
+			target: `{"baz": "qux"}`,
+			patch:  `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			err:    ErrTestFailed,
+		},
+		{
+			name:   "A.10 adding a nested member object",
+			target: `{"foo": "bar"}`,
+			patch:  `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want:   `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name:   "A.11 ignoring unrecognized elements",
+			target: `{"foo": "bar"}`,
+			patch:  `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			want:   `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:   "A.12 adding to a nonexistent target",
+			target: `{"foo": "bar"}`,
+			patch:  `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			err:    ErrPathNotFound,
+		},
+		{
+			name:   "A.13 invalid JSON patch document",
+			target: `{"foo": "bar"}`,
+			patch:  `[{"op": "add", "path": "/baz", "value": "qux", "op": "remove"}]`,
+			err:    ErrPathNotFound,
+		},
+		{
+			name:   "A.14 ~ escape ordering",
+			target: `{"/": 9, "~1": 10}`,
+			patch:  `[{"op": "test", "path": "/~01", "value": 10}]`,
+			want:   `{"/": 9, "~1": 10}`,
+		},
+		{
+			name:   "A.15 comparing strings and numbers",
+			target: `{"/": 9, "~1": 10}`,
+			patch:  `[{"op": "test", "path": "/~01", "value": "10"}]`,
+			err:    ErrTestFailed,
+		},
+		{
+			name:   "A.16 adding an array value",
+			target: `{"foo": ["bar"]}`,
+			patch:  `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want:   `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		{
+			name:   "replacing with null",
+			target: `{"a": "b"}`,
+			patch:  `[{"op": "replace", "path": "/a", "value": null}]`,
+			want:   `{"a": null}`,
+		},
+		{
+			name:   "testing null",
+			target: `{"a": null}`,
+			patch:  `[{"op": "test", "path": "/a", "value": null}]`,
+			want:   `{"a": null}`,
+		},
+		{
+			name:   "missing value",
+			target: `{"a": "b"}`,
+			patch:  `[{"op": "replace", "path": "/a"}]`,
+			err:    ErrInvalidPatch,
+		},
+		{
+			name:   "moving a value into one of its children",
+			target: `{"a": {"b": {}}}`,
+			patch:  `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`,
+			err:    ErrInvalidPath,
+		},
+		{
+			name:   "leading zero array index",
+			target: `{"foo": ["bar", "baz"]}`,
+			patch:  `[{"op": "remove", "path": "/foo/01"}]`,
+			err:    ErrInvalidPath,
+		},
+		{
+			name:   "failing operation aborts the patch",
+			target: `{"foo": "bar"}`,
+			patch:  `[{"op": "add", "path": "/baz", "value": "qux"}, {"op": "remove", "path": "/missing"}]`,
+			err:    ErrPathNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.target), []byte(tt.patch))
+			check(t, got, err, tt.want, tt.err)
+		})
+	}
+}
+
+// the examples of RFC 7396 Appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.patch, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.target), []byte(tt.patch))
+			check(t, got, err, tt.want, nil)
+		})
+	}
+}
+
+func check(t *testing.T, got []byte, err error, want string, wantErr error) {
+	t.Helper()
+
+	if wantErr != nil {
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("got error %v, want %v", err, wantErr)
+		}
+		return
+	}
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gotDoc, err := decode(got)
+	if err != nil {
+		t.Fatalf("invalid result %s: %v", got, err)
+	}
+
+	wantDoc, err := decode([]byte(want))
+	if err != nil {
+		t.Fatalf("invalid expected document %s: %v", want, err)
+	}
+
+	if !equal(gotDoc, wantDoc) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -2,11 +2,17 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"html/template"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/go-mail/mail/v2"
+	"github.com/k1nho/letsgo/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed "templates"
@@ -28,7 +34,18 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+// Send: renders the template and sends it to the recipient, the whole attempt (retries included) is recorded as a span of ctx
+func (m Mailer) Send(ctx context.Context, recipient, templateFile string, data interface{}) (err error) {
+	_, span := tracing.Start(ctx, "mailer.Send", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("mail.template", templateFile),
+		attribute.String("server.address", m.dialer.Host),
+		attribute.Int("server.port", m.dialer.Port),
+	))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
@@ -70,3 +87,15 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 
 	return err
 }
+
+// Ping: checks that the SMTP server accepts TCP connections, without authenticating or sending anything
+func (m Mailer) Ping(ctx context.Context) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(m.dialer.Host, strconv.Itoa(m.dialer.Port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
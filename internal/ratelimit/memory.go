@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Memory: keeps the buckets in process memory, every replica of the server limits on its own
+type Memory struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	// now: the clock the buckets are refilled with, replaced in tests
+	now func() time.Time
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemory: returns an in-memory limiter, buckets not used for idleTTL are dropped by a background goroutine
+func NewMemory(idleTTL time.Duration) *Memory {
+	m := &Memory{clients: make(map[string]*client), now: time.Now}
+
+	// This goroutine spins up every minute and cleans up clients that have not used the API within idleTTL
+	// This keeps our clients map from growing infinitely
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			m.mu.Lock()
+
+			for key, client := range m.clients {
+				if m.now().Sub(client.lastSeen) > idleTTL {
+					delete(m.clients, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}()
+
+	return m
+}
+
+func (m *Memory) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(limit.RPS), limit.Burst)}
+		m.clients[key] = c
+	}
+
+	// the limit may have changed since the bucket was created
+	if c.limiter.Limit() != rate.Limit(limit.RPS) {
+		c.limiter.SetLimitAt(now, rate.Limit(limit.RPS))
+	}
+	if c.limiter.Burst() != limit.Burst {
+		c.limiter.SetBurstAt(now, limit.Burst)
+	}
+
+	c.lastSeen = now
+
+	allowed := c.limiter.AllowN(now, 1)
+
+	return result(allowed, c.limiter.TokensAt(now), limit), nil
+}
+
+func (m *Memory) Peek(_ context.Context, key string, limit Limit) (Result, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		return result(limit.Burst >= 1, float64(limit.Burst), limit), nil
+	}
+
+	tokens := min(c.limiter.TokensAt(now), float64(limit.Burst))
+
+	return result(tokens >= 1, tokens, limit), nil
+}
@@ -0,0 +1,12 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	testLimiter(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "", func(now func() time.Time) RateLimiter {
+		return &Memory{clients: make(map[string]*client), now: now}
+	})
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Postgres: keeps the buckets in the rate_limits table, so every replica sharing the database shares the limits
+type Postgres struct {
+	DB *sql.DB
+	// now: the clock the buckets are refilled with, the database clock when nil. Replaced in tests
+	now func() time.Time
+}
+
+// NewPostgres: returns a limiter backed by PostgreSQL, buckets not used for idleTTL are deleted by a background goroutine
+// (an idle bucket is full again after burst/rps seconds, so dropping it doesn't change any decision)
+func NewPostgres(db *sql.DB, idleTTL time.Duration, onError func(error)) *Postgres {
+	p := &Postgres{DB: db}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			err := p.deleteIdle(idleTTL)
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	return p
+}
+
+// clock: the current time of the statements, $4 is only set when the limiter has its own clock
+const clock = `COALESCE($4::timestamptz, now())`
+
+// refill: the tokens in the bucket after refilling it for the time elapsed since its last update, capped at the burst
+const refill = `LEAST($3::double precision, rl.tokens + GREATEST(0, EXTRACT(EPOCH FROM ` + clock + ` - rl.updated_at))::double precision * $2::double precision)`
+
+// Allow: refills and takes from the bucket in a single statement, the row lock taken by the upsert serializes
+// concurrent requests of the same key across replicas. Time comes from the database clock so replicas with skewed
+// clocks still agree
+func (p *Postgres) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	query := fmt.Sprintf(`
+        INSERT INTO rate_limits AS rl (key, tokens, allowed, updated_at)
+        VALUES ($1, $3::double precision - 1, $3::double precision >= 1, %[2]s)
+        ON CONFLICT (key) DO UPDATE
+        SET tokens = %[1]s - CASE WHEN %[1]s >= 1 THEN 1 ELSE 0 END,
+            allowed = %[1]s >= 1,
+            updated_at = %[2]s
+        RETURNING tokens, allowed`, refill, clock)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var tokens float64
+	var allowed bool
+
+	err := p.DB.QueryRowContext(ctx, query, key, limit.RPS, limit.Burst, p.clock()).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result(allowed, tokens, limit), nil
+}
+
+// Peek: reads the refilled bucket without updating it, a key without a row has a full bucket
+func (p *Postgres) Peek(ctx context.Context, key string, limit Limit) (Result, error) {
+	query := fmt.Sprintf(`
+        SELECT %s
+        FROM rate_limits AS rl
+        WHERE rl.key = $1`, refill)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tokens := float64(limit.Burst)
+
+	err := p.DB.QueryRowContext(ctx, query, key, limit.RPS, limit.Burst, p.clock()).Scan(&tokens)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Result{}, err
+	}
+
+	return result(tokens >= 1, tokens, limit), nil
+}
+
+// clock: the value of the $4 parameter, NULL to use the database clock
+func (p *Postgres) clock() any {
+	if p.now == nil {
+		return nil
+	}
+	return p.now()
+}
+
+func (p *Postgres) deleteIdle(idleTTL time.Duration) error {
+	query := `
+        DELETE FROM rate_limits
+        WHERE updated_at < now() - $1::double precision * INTERVAL '1 second'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, query, idleTTL.Seconds())
+	return err
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// TestPostgres: runs against the database of GREENLIGHT_TEST_DB_DSN, migrated up to the rate_limits table
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	prefix := "test:" + t.Name() + ":"
+
+	deleteKeys := func() {
+		_, err := db.ExecContext(context.Background(), `DELETE FROM rate_limits WHERE starts_with(key, $1)`, prefix)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	deleteKeys()
+	defer deleteKeys()
+
+	// the buckets are updated at the time of the test clock, a recent one keeps them safe from the idle cleanup of a
+	// server sharing the database
+	start := time.Now().Truncate(time.Second)
+
+	testLimiter(t, start, prefix, func(now func() time.Time) RateLimiter {
+		return &Postgres{DB: db, now: now}
+	})
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit: a token bucket refilled at RPS tokens per second holding at most Burst tokens, each request takes one token
+type Limit struct {
+	RPS   float64
+	Burst int
+}
+
+// Result: the outcome of a request against a bucket
+type Result struct {
+	Allowed bool
+	// Remaining: whole tokens left in the bucket after the request
+	Remaining int
+	// RetryAfter: how long until the next token is available, zero when the request was allowed
+	RetryAfter time.Duration
+}
+
+// RateLimiter: token bucket rate limiting keyed by client. The limit is given on every call rather than when the limiter is
+// created, so it can be reloaded at runtime and differ between keys
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	// Peek: reports whether a request would be allowed without taking a token, for buckets charged only on failures
+	Peek(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// result: builds the result from the tokens left in the bucket after the request
+func result(allowed bool, tokens float64, limit Limit) Result {
+	res := Result{Allowed: allowed, Remaining: int(math.Max(0, math.Floor(tokens)))}
+
+	if !allowed && limit.RPS > 0 {
+		res.RetryAfter = time.Duration((1 - tokens) / limit.RPS * float64(time.Second))
+	}
+
+	return res
+}
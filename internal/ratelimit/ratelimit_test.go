@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testClock: a clock only moving when advanced, so refills are exact
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+type step struct {
+	// advance: moves the clock before the request
+	advance time.Duration
+	// key: the bucket of the request, "a" when empty
+	key  string
+	peek bool
+	want Result
+}
+
+var limiterTests = []struct {
+	name  string
+	limit Limit
+	steps []step
+}{
+	{
+		name:  "burst then denied",
+		limit: Limit{RPS: 1, Burst: 3},
+		steps: []step{
+			{want: Result{Allowed: true, Remaining: 2}},
+			{want: Result{Allowed: true, Remaining: 1}},
+			{want: Result{Allowed: true, Remaining: 0}},
+			{want: Result{Allowed: false, Remaining: 0, RetryAfter: time.Second}},
+			{want: Result{Allowed: false, Remaining: 0, RetryAfter: time.Second}},
+		},
+	},
+	{
+		name:  "refill over time",
+		limit: Limit{RPS: 2, Burst: 2},
+		steps: []step{
+			{want: Result{Allowed: true, Remaining: 1}},
+			{want: Result{Allowed: true, Remaining: 0}},
+			{want: Result{Allowed: false, Remaining: 0, RetryAfter: 500 * time.Millisecond}},
+			{advance: 250 * time.Millisecond, want: Result{Allowed: false, Remaining: 0, RetryAfter: 250 * time.Millisecond}},
+			{advance: 250 * time.Millisecond, want: Result{Allowed: true, Remaining: 0}},
+			{advance: 750 * time.Millisecond, want: Result{Allowed: true, Remaining: 0}},
+			{want: Result{Allowed: false, Remaining: 0, RetryAfter: 250 * time.Millisecond}},
+		},
+	},
+	{
+		name:  "refill capped at burst",
+		limit: Limit{RPS: 1, Burst: 3},
+		steps: []step{
+			{want: Result{Allowed: true, Remaining: 2}},
+			{want: Result{Allowed: true, Remaining: 1}},
+			{advance: time.Hour, want: Result{Allowed: true, Remaining: 2}},
+			{want: Result{Allowed: true, Remaining: 1}},
+			{want: Result{Allowed: true, Remaining: 0}},
+			{want: Result{Allowed: false, Remaining: 0, RetryAfter: time.Second}},
+		},
+	},
+	{
+		name:  "peek doesn't take tokens",
+		limit: Limit{RPS: 1, Burst: 2},
+		steps: []step{
+			{peek: true, want: Result{Allowed: true, Remaining: 2}},
+			{peek: true, want: Result{Allowed: true, Remaining: 2}},
+			{want: Result{Allowed: true, Remaining: 1}},
+			{peek: true, want: Result{Allowed: true, Remaining: 1}},
+			{peek: true, want: Result{Allowed: true, Remaining: 1}},
+			{want: Result{Allowed: true, Remaining: 0}},
+			{peek: true, want: Result{Allowed: false, Remaining: 0, RetryAfter: time.Second}},
+			{peek: true, want: Result{Allowed: false, Remaining: 0, RetryAfter: time.Second}},
+			{advance: time.Second, peek: true, want: Result{Allowed: true, Remaining: 1}},
+			{want: Result{Allowed: true, Remaining: 0}},
+		},
+	},
+	{
+		name:  "peek refills up to burst",
+		limit: Limit{RPS: 1, Burst: 2},
+		steps: []step{
+			{want: Result{Allowed: true, Remaining: 1}},
+			{want: Result{Allowed: true, Remaining: 0}},
+			{advance: time.Minute, peek: true, want: Result{Allowed: true, Remaining: 2}},
+		},
+	},
+	{
+		name:  "keys have their own bucket",
+		limit: Limit{RPS: 1, Burst: 1},
+		steps: []step{
+			{want: Result{Allowed: true, Remaining: 0}},
+			{want: Result{Allowed: false, Remaining: 0, RetryAfter: time.Second}},
+			{key: "b", peek: true, want: Result{Allowed: true, Remaining: 1}},
+			{key: "b", want: Result{Allowed: true, Remaining: 0}},
+			{key: "b", want: Result{Allowed: false, Remaining: 0, RetryAfter: time.Second}},
+		},
+	},
+}
+
+// testLimiter: runs the steps of limiterTests against a limiter created for each test with a clock starting at start.
+// prefix is prepended to the keys
+func testLimiter(t *testing.T, start time.Time, prefix string, newLimiter func(now func() time.Time) RateLimiter) {
+	t.Helper()
+
+	for _, tt := range limiterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &testClock{t: start}
+			limiter := newLimiter(clock.now)
+
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+
+				key := s.key
+				if key == "" {
+					key = "a"
+				}
+				key = prefix + tt.name + ":" + key
+
+				var got Result
+				var err error
+				if s.peek {
+					got, err = limiter.Peek(context.Background(), key, tt.limit)
+				} else {
+					got, err = limiter.Allow(context.Background(), key, tt.limit)
+				}
+				if err != nil {
+					t.Fatalf("step %d: unexpected error: %v", i, err)
+				}
+
+				if got != s.want {
+					t.Errorf("step %d: got %+v, want %+v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed bool
+		tokens  float64
+		limit   Limit
+		want    Result
+	}{
+		{name: "whole tokens left", allowed: true, tokens: 2.75, limit: Limit{RPS: 1, Burst: 5}, want: Result{Allowed: true, Remaining: 2}},
+		{name: "empty bucket", allowed: false, tokens: 0, limit: Limit{RPS: 4, Burst: 5}, want: Result{Remaining: 0, RetryAfter: 250 * time.Millisecond}},
+		{name: "partial token", allowed: false, tokens: 0.5, limit: Limit{RPS: 1, Burst: 5}, want: Result{Remaining: 0, RetryAfter: 500 * time.Millisecond}},
+		{name: "negative tokens", allowed: false, tokens: -1, limit: Limit{RPS: 1, Burst: 5}, want: Result{Remaining: 0, RetryAfter: 2 * time.Second}},
+		{name: "no refill", allowed: false, tokens: 0, limit: Limit{RPS: 0, Burst: 5}, want: Result{Remaining: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := result(tt.allowed, tt.tokens, tt.limit)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
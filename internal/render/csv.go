@@ -0,0 +1,121 @@
+package render
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+var ErrNotTabular = errors.New("render: value has no list to write as CSV")
+
+// CSV: writes the list of an envelope (an object holding exactly one array of objects, e.g {"movies": [...], "metadata": {...}})
+// as CSV with a header row. The columns are the keys of the items in the order they are first seen, lists of scalars are
+// joined with ; and nested objects are written as JSON
+func CSV(w io.Writer, v Value) error {
+	rows, ok := tabular(v)
+	if !ok {
+		return ErrNotTabular
+	}
+
+	var columns []string
+	index := make(map[string]int)
+
+	for _, row := range rows {
+		for _, field := range row.Fields {
+			if _, ok := index[field.Key]; !ok {
+				index[field.Key] = len(columns)
+				columns = append(columns, field.Key)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i := range record {
+			record[i] = ""
+		}
+
+		for _, field := range row.Fields {
+			record[index[field.Key]] = csvCell(field.Value)
+		}
+
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func tabular(v Value) ([]Value, bool) {
+	if v.Kind != Object {
+		return nil, false
+	}
+
+	var rows []Value
+	found := 0
+
+	for _, field := range v.Fields {
+		if field.Value.Kind == Array {
+			rows = field.Value.Items
+			found++
+		}
+	}
+
+	if found != 1 {
+		return nil, false
+	}
+
+	for _, row := range rows {
+		if row.Kind != Object {
+			return nil, false
+		}
+	}
+
+	return rows, true
+}
+
+func csvCell(v Value) string {
+	switch v.Kind {
+	case Bool:
+		if v.Bool {
+			return "true"
+		}
+		return "false"
+	case Number:
+		return string(v.Number)
+	case String:
+		return escapeFormula(v.String)
+	case Array:
+		cells := make([]string, len(v.Items))
+		for i, item := range v.Items {
+			cells[i] = csvCell(item)
+		}
+		return strings.Join(cells, ";")
+	case Object:
+		var sb strings.Builder
+		writeJSON(&sb, v)
+		return sb.String()
+	default:
+		return ""
+	}
+}
+
+// escapeFormula: spreadsheets evaluate cells starting with one of these characters as formulas, a leading quote makes
+// them text. Only strings are escaped, numbers are written by the API itself and negative ones must stay numbers
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
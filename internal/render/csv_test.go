@@ -0,0 +1,90 @@
+package render
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		err  error
+	}{
+		{
+			name: "list with metadata",
+			in:   `{"movies": [{"id": 1, "title": "Moana", "year": 2016}, {"id": 2, "title": "Dune", "year": 2021}], "metadata": {"total_records": 2}}`,
+			want: "id,title,year\n1,Moana,2016\n2,Dune,2021\n",
+		},
+		{
+			name: "columns in order of first appearance",
+			in:   `{"rows": [{"a": 1}, {"b": 2, "a": 3}]}`,
+			want: "a,b\n1,\n3,2\n",
+		},
+		{
+			name: "empty list",
+			in:   `{"rows": []}`,
+			want: "\n",
+		},
+		{
+			name: "lists joined and objects as JSON",
+			in:   `{"rows": [{"genres": ["drama", "comedy"], "runtime": {"mins": 102}, "seen": false, "note": null}]}`,
+			want: "genres,runtime,seen,note\ndrama;comedy,\"{\"\"mins\"\":102}\",false,\n",
+		},
+		{
+			name: "formulas escaped",
+			in:   `{"rows": [{"a": "=HYPERLINK(\"http://x\")", "b": "+1", "c": "-1", "d": "@SUM(A1)", "e": "\tx", "f": "\rx"}]}`,
+			want: "a,b,c,d,e,f\n\"'=HYPERLINK(\"\"http://x\"\")\",'+1,'-1,'@SUM(A1),'\tx,\"'\rx\"\n",
+		},
+		{
+			name: "formulas escaped inside lists",
+			in:   `{"rows": [{"genres": ["=1+1", "drama"]}]}`,
+			want: "genres\n'=1+1;drama\n",
+		},
+		{
+			name: "negative numbers are not escaped",
+			in:   `{"rows": [{"balance": -5, "text": "a=b"}]}`,
+			want: "balance,text\n-5,a=b\n",
+		},
+		{
+			name: "single record",
+			in:   `{"movie": {"id": 1}}`,
+			err:  ErrNotTabular,
+		},
+		{
+			name: "two lists",
+			in:   `{"movies": [], "genres": []}`,
+			err:  ErrNotTabular,
+		},
+		{
+			name: "list of scalars",
+			in:   `{"ids": [1, 2]}`,
+			err:  ErrNotTabular,
+		},
+		{
+			name: "bare array",
+			in:   `[{"id": 1}]`,
+			err:  ErrNotTabular,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+
+			err := CSV(&sb, mustParse(t, tt.in))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if got := sb.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
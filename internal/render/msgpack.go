@@ -0,0 +1,96 @@
+package render
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+// MessagePack: writes the value in the MessagePack format (https://github.com/msgpack/msgpack/blob/master/spec.md),
+// integers use the smallest encoding holding them and other numbers are 64 bit floats
+func MessagePack(w io.Writer, v Value) error {
+	buf := appendMsgpack(nil, v)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendMsgpack(b []byte, v Value) []byte {
+	switch v.Kind {
+	case Bool:
+		if v.Bool {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case Number:
+		if i, err := strconv.ParseInt(string(v.Number), 10, 64); err == nil {
+			return appendMsgpackInt(b, i)
+		}
+		if u, err := strconv.ParseUint(string(v.Number), 10, 64); err == nil {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), u)
+		}
+		f, _ := strconv.ParseFloat(string(v.Number), 64)
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+	case String:
+		return appendMsgpackString(b, v.String)
+	case Array:
+		b = appendMsgpackHeader(b, len(v.Items), 0x90, 0xdc, 0xdd)
+		for _, item := range v.Items {
+			b = appendMsgpack(b, item)
+		}
+		return b
+	case Object:
+		b = appendMsgpackHeader(b, len(v.Fields), 0x80, 0xde, 0xdf)
+		for _, field := range v.Fields {
+			b = appendMsgpackString(b, field.Key)
+			b = appendMsgpack(b, field.Value)
+		}
+		return b
+	default:
+		return append(b, 0xc0)
+	}
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 127:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(int16(i)))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(int32(i)))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackHeader: arrays and maps share the same layout, a fix type for up to 15 entries then 16 and 32 bit lengths
+func appendMsgpackHeader(b []byte, n int, fix, len16, len32 byte) []byte {
+	switch {
+	case n <= 15:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, len16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, len32), uint32(n))
+	}
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMessagePack(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []byte
+	}{
+		{name: "nil", in: `null`, want: []byte{0xc0}},
+		{name: "true", in: `true`, want: []byte{0xc3}},
+		{name: "false", in: `false`, want: []byte{0xc2}},
+		{name: "positive fixint", in: `0`, want: []byte{0x00}},
+		{name: "largest positive fixint", in: `127`, want: []byte{0x7f}},
+		{name: "negative fixint", in: `-1`, want: []byte{0xff}},
+		{name: "smallest negative fixint", in: `-32`, want: []byte{0xe0}},
+		{name: "int 8", in: `-33`, want: []byte{0xd0, 0xdf}},
+		{name: "int 16", in: `128`, want: []byte{0xd1, 0x00, 0x80}},
+		{name: "negative int 16", in: `-300`, want: []byte{0xd1, 0xfe, 0xd4}},
+		{name: "int 32", in: `70000`, want: []byte{0xd2, 0x00, 0x01, 0x11, 0x70}},
+		{name: "int 64", in: `4294967296`, want: []byte{0xd3, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{name: "uint 64", in: `18446744073709551615`, want: []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "float", in: `1.5`, want: []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{name: "negative float", in: `-0.25`, want: []byte{0xcb, 0xbf, 0xd0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{name: "exponent", in: `1e3`, want: []byte{0xcb, 0x40, 0x8f, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{name: "empty string", in: `""`, want: []byte{0xa0}},
+		{name: "fixstr", in: `"hi"`, want: []byte{0xa2, 'h', 'i'}},
+		{name: "utf-8 length in bytes", in: `"é"`, want: []byte{0xa2, 0xc3, 0xa9}},
+		{name: "str 8", in: `"` + strings.Repeat("a", 32) + `"`, want: append([]byte{0xd9, 0x20}, strings.Repeat("a", 32)...)},
+		{name: "str 16", in: `"` + strings.Repeat("a", 256) + `"`, want: append([]byte{0xda, 0x01, 0x00}, strings.Repeat("a", 256)...)},
+		{name: "empty array", in: `[]`, want: []byte{0x90}},
+		{name: "fixarray", in: `[1, "a", null]`, want: []byte{0x93, 0x01, 0xa1, 'a', 0xc0}},
+		{name: "array 16", in: `[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0]`, want: append([]byte{0xdc, 0x00, 0x10}, make([]byte, 16)...)},
+		{name: "empty map", in: `{}`, want: []byte{0x80}},
+		{name: "fixmap in key order", in: `{"b": 1, "a": null}`, want: []byte{0x82, 0xa1, 'b', 0x01, 0xa1, 'a', 0xc0}},
+		{
+			name: "nested",
+			in:   `{"movie": {"genres": ["x"], "year": 2016}}`,
+			want: []byte{0x81, 0xa5, 'm', 'o', 'v', 'i', 'e', 0x82, 0xa6, 'g', 'e', 'n', 'r', 'e', 's', 0x91, 0xa1, 'x', 0xa4, 'y', 'e', 'a', 'r', 0xd1, 0x07, 0xe0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			err := MessagePack(&buf, mustParse(t, tt.in))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := buf.Bytes(); !bytes.Equal(got, tt.want) {
+				t.Errorf("got % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagePackMap16(t *testing.T) {
+	var js strings.Builder
+	js.WriteByte('{')
+	for i := range 16 {
+		if i > 0 {
+			js.WriteByte(',')
+		}
+		js.WriteString(`"` + string(rune('a'+i)) + `":0`)
+	}
+	js.WriteByte('}')
+
+	var buf bytes.Buffer
+
+	err := MessagePack(&buf, mustParse(t, js.String()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []byte{0xde, 0x00, 0x10}
+	if got := buf.Bytes()[:3]; !bytes.Equal(got, want) {
+		t.Errorf("got header % x, want % x", got, want)
+	}
+	if got := buf.Len(); got != 3+16*3 {
+		t.Errorf("got %d bytes, want %d", got, 3+16*3)
+	}
+}
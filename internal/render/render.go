@@ -0,0 +1,142 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Responses are marshalled to JSON first and rendered from the parsed document, so every format honors the json
+// struct tags and custom MarshalJSON methods (e.g data.Runtime) and a field looks the same whatever the format
+
+type Kind int
+
+const (
+	Null Kind = iota
+	Bool
+	Number
+	String
+	Array
+	Object
+)
+
+// Value: a JSON value, objects keep the order of their keys so the other formats list fields in the same order as JSON
+type Value struct {
+	Kind   Kind
+	Bool   bool
+	Number json.Number
+	String string
+	Items  []Value
+	Fields []Field
+}
+
+type Field struct {
+	Key   string
+	Value Value
+}
+
+// Parse: parses a single JSON value
+func Parse(js []byte) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	v, err := parseValue(dec)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if dec.More() {
+		return Value{}, errors.New("render: trailing data after JSON value")
+	}
+
+	return v, nil
+}
+
+func parseValue(dec *json.Decoder) (Value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch tok := tok.(type) {
+	case nil:
+		return Value{Kind: Null}, nil
+	case bool:
+		return Value{Kind: Bool, Bool: tok}, nil
+	case json.Number:
+		return Value{Kind: Number, Number: tok}, nil
+	case string:
+		return Value{Kind: String, String: tok}, nil
+	case json.Delim:
+		switch tok {
+		case '[':
+			v := Value{Kind: Array, Items: []Value{}}
+			for dec.More() {
+				item, err := parseValue(dec)
+				if err != nil {
+					return Value{}, err
+				}
+				v.Items = append(v.Items, item)
+			}
+			_, err = dec.Token()
+			return v, err
+		case '{':
+			v := Value{Kind: Object, Fields: []Field{}}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return Value{}, err
+				}
+
+				value, err := parseValue(dec)
+				if err != nil {
+					return Value{}, err
+				}
+				v.Fields = append(v.Fields, Field{Key: key.(string), Value: value})
+			}
+			_, err = dec.Token()
+			return v, err
+		}
+	}
+
+	return Value{}, fmt.Errorf("render: unexpected JSON token %v", tok)
+}
+
+// writeJSON: writes the value back as compact JSON, keeping the order of the keys
+func writeJSON(sb *strings.Builder, v Value) {
+	switch v.Kind {
+	case Bool:
+		sb.WriteString(strconv.FormatBool(v.Bool))
+	case Number:
+		sb.WriteString(string(v.Number))
+	case String:
+		js, _ := json.Marshal(v.String)
+		sb.Write(js)
+	case Array:
+		sb.WriteByte('[')
+		for i, item := range v.Items {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeJSON(sb, item)
+		}
+		sb.WriteByte(']')
+	case Object:
+		sb.WriteByte('{')
+		for i, field := range v.Fields {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			key, _ := json.Marshal(field.Key)
+			sb.Write(key)
+			sb.WriteByte(':')
+			writeJSON(sb, field.Value)
+		}
+		sb.WriteByte('}')
+	default:
+		sb.WriteString("null")
+	}
+}
@@ -0,0 +1,35 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, js string) Value {
+	t.Helper()
+
+	v, err := Parse([]byte(js))
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", js, err)
+	}
+	return v
+}
+
+func TestParseKeepsKeyOrder(t *testing.T) {
+	v := mustParse(t, `{"b": 1, "a": {"d": null, "c": [true, "x"]}}`)
+
+	var sb strings.Builder
+	writeJSON(&sb, v)
+
+	want := `{"b":1,"a":{"d":null,"c":[true,"x"]}}`
+	if got := sb.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseTrailingData(t *testing.T) {
+	_, err := Parse([]byte(`{} {}`))
+	if err == nil {
+		t.Error("got no error for trailing data")
+	}
+}
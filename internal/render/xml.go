@@ -0,0 +1,77 @@
+package render
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// XML: writes the value as an XML document under a root element. Object fields become elements named after their key,
+// array items become item elements and null values are empty elements. Keys that aren't valid element names
+// (e.g "200" or "movies:read") are written as entry elements with the key in an attribute
+func XML(w io.Writer, root string, v Value) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString(xml.Header)
+	writeXMLElement(bw, root, v)
+	bw.WriteByte('\n')
+
+	return bw.Flush()
+}
+
+func writeXMLElement(w *bufio.Writer, name string, v Value) {
+	if validXMLName(name) {
+		w.WriteString("<" + name + ">")
+	} else {
+		w.WriteString(`<entry key="`)
+		xml.EscapeText(w, []byte(name))
+		w.WriteString(`">`)
+	}
+
+	switch v.Kind {
+	case Bool:
+		if v.Bool {
+			w.WriteString("true")
+		} else {
+			w.WriteString("false")
+		}
+	case Number:
+		w.WriteString(string(v.Number))
+	case String:
+		xml.EscapeText(w, []byte(v.String))
+	case Array:
+		for _, item := range v.Items {
+			writeXMLElement(w, "item", item)
+		}
+	case Object:
+		for _, field := range v.Fields {
+			writeXMLElement(w, field.Key, field.Value)
+		}
+	}
+
+	if validXMLName(name) {
+		w.WriteString("</" + name + ">")
+	} else {
+		w.WriteString("</entry>")
+	}
+}
+
+// validXMLName: a conservative subset of the XML name production, letters, digits, _ - and . not starting with a digit,
+// - or . and not starting with xml (reserved)
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,81 @@
+package render
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestXML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "scalars",
+			in:   `{"id": 1, "title": "Moana", "seen": true, "deleted": false, "note": null}`,
+			want: "<response><id>1</id><title>Moana</title><seen>true</seen><deleted>false</deleted><note></note></response>",
+		},
+		{
+			name: "arrays as items",
+			in:   `{"genres": ["drama", "comedy"], "empty": []}`,
+			want: "<response><genres><item>drama</item><item>comedy</item></genres><empty></empty></response>",
+		},
+		{
+			name: "nested objects",
+			in:   `{"movie": {"id": 1, "runtime": "102 mins"}}`,
+			want: "<response><movie><id>1</id><runtime>102 mins</runtime></movie></response>",
+		},
+		{
+			name: "values escaped",
+			in:   `{"title": "<b>Tom & Jerry</b> \"quoted\""}`,
+			want: "<response><title>&lt;b&gt;Tom &amp; Jerry&lt;/b&gt; &#34;quoted&#34;</title></response>",
+		},
+		{
+			name: "keys that aren't element names",
+			in:   `{"movies:read": 1, "200": 2, "xmlns": 3, "-a": 4, "": 5}`,
+			want: `<response><entry key="movies:read">1</entry><entry key="200">2</entry><entry key="xmlns">3</entry>` +
+				`<entry key="-a">4</entry><entry key="">5</entry></response>`,
+		},
+		{
+			name: "keys escaped",
+			in:   `{"a\"><b c=\"&": "x", "</response>": "y"}`,
+			want: `<response><entry key="a&#34;&gt;&lt;b c=&#34;&amp;">x</entry><entry key="&lt;/response&gt;">y</entry></response>`,
+		},
+		{
+			name: "valid names kept",
+			in:   `{"a1": 1, "snake_case": 2, "kebab-case.v2": 3}`,
+			want: "<response><a1>1</a1><snake_case>2</snake_case><kebab-case.v2>3</kebab-case.v2></response>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+
+			err := XML(&sb, "response", mustParse(t, tt.in))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := xml.Header + tt.want + "\n"
+			if got := sb.String(); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+
+			// the output must be well formed whatever the keys and values are
+			dec := xml.NewDecoder(strings.NewReader(sb.String()))
+			for {
+				_, err := dec.Token()
+				if err != nil {
+					if err != io.EOF {
+						t.Errorf("invalid XML: %v", err)
+					}
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName: the name of the tracer of the spans started by this module
+const instrumentationName = "github.com/k1nho/letsgo"
+
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+// Config: how traces are sampled and where they are sent
+type Config struct {
+	// Exporter: none, stdout or otlp. With none, spans are still created so trace ids are propagated and logged
+	Exporter string
+	// Endpoint: the OTLP/HTTP traces URL (e.g http://localhost:4318/v1/traces)
+	Endpoint    string
+	ServiceName string
+	// SampleRatio: fraction of new traces that are sampled, traces continued from a parent follow the parent decision
+	SampleRatio float64
+	// Stdout: where the stdout exporter writes
+	Stdout io.Writer
+}
+
+var provider *sdktrace.TracerProvider
+
+// Setup: installs the OpenTelemetry tracer provider and the W3C trace context propagator globally, so the spans of
+// instrumented libraries join the traces of the application
+func Setup(ctx context.Context, cfg Config) error {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+	if err != nil {
+		return err
+	}
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	}
+
+	switch cfg.Exporter {
+	case "none":
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(cfg.Stdout))
+		if err != nil {
+			return err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case "otlp":
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		if err != nil {
+			return err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	default:
+		return ErrUnknownExporter
+	}
+
+	provider = sdktrace.NewTracerProvider(options...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return nil
+}
+
+// Shutdown: flushes the spans still buffered by the exporter, if Setup was called
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Start: starts a span as a child of the span in ctx with the tracer of the application
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// RecordError: records the error on the span and marks it as failed, nil errors are ignored so calls can be unconditional
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
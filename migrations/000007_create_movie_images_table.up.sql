@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_images(
+    id bigserial PRIMARY KEY,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size bigint NOT NULL,
+    blob_key TEXT NOT NULL,
+    thumbnail_key TEXT NOT NULL,
+    url TEXT NOT NULL,
+    thumbnail_url TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_images_movie_id_idx ON movie_images(movie_id);
@@ -0,0 +1,7 @@
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_genres_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK(year BETWEEN 1888 AND date_part('year', now()));
+ALTER TABLE movies ADD CONSTRAINT movies_genres_check CHECK(array_length(genres, 1) BETWEEN 1 AND 5);
+
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS genres(
+    id bigserial PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL
+);
+
+INSERT INTO genres(name)
+SELECT DISTINCT unnest(genres) FROM movies
+ON CONFLICT DO NOTHING;
+
+-- the title, year and genre limits are enforced by the configurable validation rules, keep only the invariants here
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_genres_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK(year > 0);
+ALTER TABLE movies ADD CONSTRAINT movies_genres_check CHECK(array_length(genres, 1) >= 1);
@@ -0,0 +1,9 @@
+-- the buckets are cheap to lose (a lost bucket just starts full again), so the table skips the WAL
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits(
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMP with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_updated_at_idx ON rate_limits(updated_at);
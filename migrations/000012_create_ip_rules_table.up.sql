@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS ip_rules(
+    id bigserial PRIMARY KEY,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    cidr CIDR UNIQUE NOT NULL,
+    action TEXT NOT NULL CHECK(action IN ('allow', 'deny')),
+    note TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO permissions(code)
+VALUES('ip_rules:read'), ('ip_rules:write');